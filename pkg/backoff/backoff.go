@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff produces exponentially increasing, fully jittered delays
+// that are bounded by a maximum.
+//
+// The n-th call to Next (starting at zero) returns a random duration
+// in the range [0, min(base*2^n, max)]. It is safe to use concurrently.
+type Backoff struct {
+	lock    sync.Mutex
+	base    time.Duration
+	max     time.Duration
+	attempt uint64
+	rand    *rand.Rand
+}
+
+// New creates a new Backoff with the given base and maximum delays,
+// seeded from the current time.
+func New(base time.Duration, max time.Duration) *Backoff {
+	return NewSeeded(base, max, time.Now().UnixNano())
+}
+
+// NewSeeded creates a new Backoff with the given base and maximum delays,
+// using the given seed for the jitter. Two Backoffs created with the same
+// arguments produce the same sequence of delays, which makes it useful for tests.
+func NewSeeded(base time.Duration, max time.Duration, seed int64) *Backoff {
+	if base < 0 {
+		base = 0
+	}
+	if max < base {
+		max = base
+	}
+	return &Backoff{
+		base: base,
+		max:  max,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Next returns the next delay and advances the attempt counter.
+func (b *Backoff) Next() time.Duration {
+	b.lock.Lock()
+	ceiling := b.ceiling(b.attempt)
+	b.attempt++
+	var delay time.Duration
+	switch {
+	case ceiling == math.MaxInt64:
+		// ceiling+1 would overflow, but dropping the top bit of a random
+		// uint64 is uniform over the whole range [0, math.MaxInt64].
+		delay = time.Duration(b.rand.Uint64() >> 1)
+	case ceiling > 0:
+		delay = time.Duration(b.rand.Int63n(int64(ceiling) + 1))
+	}
+	b.lock.Unlock()
+	return delay
+}
+
+// Attempt returns the number of times Next has been called since the
+// Backoff was created or last Reset.
+func (b *Backoff) Attempt() (attempt uint64) {
+	b.lock.Lock()
+	attempt = b.attempt
+	b.lock.Unlock()
+	return
+}
+
+// Reset resets the attempt counter so the next delay starts from the base again.
+func (b *Backoff) Reset() {
+	b.lock.Lock()
+	b.attempt = 0
+	b.lock.Unlock()
+}
+
+// ceiling is an internal function used to get the upper bound of the
+// delay for the given attempt, which is min(base*2^attempt, max).
+func (b *Backoff) ceiling(attempt uint64) time.Duration {
+	if b.base == 0 {
+		return 0
+	}
+	if attempt >= 63 || b.base > b.max>>attempt {
+		return b.max
+	}
+	return b.base << attempt
+}
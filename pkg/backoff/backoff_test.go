@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package backoff
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	base := 10 * time.Millisecond
+	max := time.Second
+
+	t.Run("bounded", func(t *testing.T) {
+		b := NewSeeded(base, max, 1)
+		for n := uint64(0); n < 100; n++ {
+			limit := max
+			if n < 7 {
+				limit = base << n
+			}
+			delay := b.Next()
+			require.GreaterOrEqualf(t, delay, time.Duration(0), "attempt: %d", n)
+			require.LessOrEqualf(t, delay, limit, "attempt: %d", n)
+		}
+		assert.Equal(t, uint64(100), b.Attempt())
+	})
+	t.Run("deterministic", func(t *testing.T) {
+		b1 := NewSeeded(base, max, 42)
+		b2 := NewSeeded(base, max, 42)
+		for i := 0; i < 20; i++ {
+			assert.Equal(t, b1.Next(), b2.Next())
+		}
+	})
+	t.Run("reset", func(t *testing.T) {
+		b := NewSeeded(base, max, 1)
+		for i := 0; i < 10; i++ {
+			b.Next()
+		}
+		b.Reset()
+		assert.Equal(t, uint64(0), b.Attempt())
+		assert.LessOrEqual(t, b.Next(), base)
+	})
+	t.Run("zero base", func(t *testing.T) {
+		b := New(0, max)
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, time.Duration(0), b.Next())
+		}
+	})
+	t.Run("max less than base", func(t *testing.T) {
+		b := NewSeeded(base, time.Millisecond, 1)
+		for i := 0; i < 10; i++ {
+			assert.LessOrEqual(t, b.Next(), base)
+		}
+	})
+	t.Run("no maximum", func(t *testing.T) {
+		b := NewSeeded(base, math.MaxInt64, 1)
+		for n := 0; n < 100; n++ {
+			require.GreaterOrEqualf(t, b.Next(), time.Duration(0), "attempt: %d", n)
+		}
+	})
+}
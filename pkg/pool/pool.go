@@ -11,8 +11,10 @@ type PointerWithReset[T any] interface {
 }
 
 type Pool[T any, P PointerWithReset[T]] struct {
-	pool sync.Pool
-	New  func() P
+	pool    sync.Pool
+	New     func() P
+	Destroy func(P)
+	valid   func(P) bool
 }
 
 func NewPool[T any, P PointerWithReset[T]](new func() P) *Pool[T, P] {
@@ -21,6 +23,18 @@ func NewPool[T any, P PointerWithReset[T]](new func() P) *Pool[T, P] {
 	}
 }
 
+// NewPoolWithValidator creates a new Pool that checks idle objects with valid
+// before handing them out. Idle objects that fail the check are discarded,
+// and passed to Destroy if it is set so that they can release their
+// resources, and Get moves on to the next idle object, constructing a new one
+// once the pool runs out, so callers never receive a stale object.
+func NewPoolWithValidator[T any, P PointerWithReset[T]](new func() P, valid func(P) bool) *Pool[T, P] {
+	return &Pool[T, P]{
+		New:   new,
+		valid: valid,
+	}
+}
+
 func (p *Pool[T, P]) Put(value P) {
 	if value != nil {
 		value.Reset()
@@ -29,9 +43,17 @@ func (p *Pool[T, P]) Put(value P) {
 }
 
 func (p *Pool[T, P]) Get() P {
-	rv, ok := p.pool.Get().(P)
-	if ok && rv != nil {
-		return rv
+	for {
+		rv, ok := p.pool.Get().(P)
+		if !ok || rv == nil {
+			break
+		}
+		if p.valid == nil || p.valid(rv) {
+			return rv
+		}
+		if p.Destroy != nil {
+			p.Destroy(rv)
+		}
 	}
 
 	return p.New()
@@ -158,3 +158,51 @@ func TestPoolAllocation(t *testing.T) {
 	})
 	assert.Equal(t, float64(0), allocs)
 }
+
+func TestPoolWithValidator(t *testing.T) {
+	type conn struct {
+		demoData
+		closed bool
+	}
+
+	constructed := 0
+	valid := func(c *conn) bool {
+		return !c.closed
+	}
+	p := NewPoolWithValidator(func() *conn {
+		constructed++
+		return new(conn)
+	}, valid)
+
+	for i := 0; i < 3; i++ {
+		c := p.Get()
+		c.closed = true
+		p.Put(c)
+	}
+	assert.Equal(t, 3, constructed)
+
+	c := p.Get()
+	assert.False(t, c.closed)
+	assert.Equal(t, 4, constructed)
+
+	for i := 0; i < 10; i++ {
+		p.Put(c)
+		c = p.Get()
+		assert.True(t, valid(c))
+	}
+
+	// sync.Pool may drop idle objects at any time, so only the objects that
+	// Get did see can be expected to be destroyed.
+	var destroyed []*conn
+	p.Destroy = func(c *conn) {
+		assert.False(t, valid(c))
+		destroyed = append(destroyed, c)
+	}
+	for i := 0; i < 100 && len(destroyed) == 0; i++ {
+		c := p.Get()
+		c.closed = true
+		p.Put(c)
+		assert.False(t, p.Get().closed)
+	}
+	assert.NotEmpty(t, destroyed)
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pool
+
+import (
+	"context"
+	"sync"
+)
+
+// PoolErr is a pool of objects whose construction can fail, such as
+// objects that open a file or dial a connection.
+//
+// Unlike Pool, which is backed by a sync.Pool, idle objects are kept in an
+// explicit free list so that they are never silently dropped by the garbage
+// collector while they still hold on to resources. An object that the pool
+// has no room for, because of the limit set with SetMaxIdle, is passed to
+// Destroy, if it is set, so that those resources can be released.
+type PoolErr[T any, P PointerWithReset[T]] struct {
+	lock       sync.Mutex
+	idle       []P
+	maxIdle    int
+	refill     int
+	refilling  bool
+	bestEffort bool
+	misses     uint64
+	New        func() (P, error)
+	Destroy    func(P)
+}
+
+func NewPoolErr[T any, P PointerWithReset[T]](new func() (P, error)) *PoolErr[T, P] {
+	return &PoolErr[T, P]{
+		New: new,
+	}
+}
+
+// SetMaxIdle sets the maximum number of idle objects the pool retains.
+// Objects that are Put while the pool is at capacity are dropped, and passed
+// to Destroy if it is set. A value of zero (the default) means the pool is unbounded.
+func (p *PoolErr[T, P]) SetMaxIdle(max int) {
+	p.lock.Lock()
+	p.maxIdle = max
+	p.lock.Unlock()
+}
+
+// SetRefill enables asynchronous refilling of the pool. Whenever Get finds the
+// pool empty or takes its last idle object, a background goroutine constructs
+// new objects until there are target idle objects, so that subsequent calls
+// to Get during a burst do not pay the construction cost. Only one refill runs
+// at a time, and it never fills the pool beyond the limit set with SetMaxIdle.
+// A target of zero (the default) disables refilling.
+//
+// While refilling is enabled, New is called from the background goroutine at
+// the same time as from callers of Get, so it must be safe for concurrent use.
+func (p *PoolErr[T, P]) SetRefill(target int) {
+	p.lock.Lock()
+	p.refill = target
+	p.lock.Unlock()
+}
+
+// SetBestEffortPrewarm sets whether PrewarmCtx keeps going when constructing an
+// object fails. By default it stops at the first failure.
+func (p *PoolErr[T, P]) SetBestEffortPrewarm(bestEffort bool) {
+	p.lock.Lock()
+	p.bestEffort = bestEffort
+	p.lock.Unlock()
+}
+
+// PrewarmCtx constructs up to n objects and adds them to the pool, so that
+// the first calls to Get do not pay the construction cost. It stops early,
+// returning the context's error, once ctx is done, and never fills the pool
+// beyond the limit set with SetMaxIdle.
+//
+// If constructing an object fails, PrewarmCtx returns the error right away, or
+// with SetBestEffortPrewarm, keeps going and returns the first error once it
+// is done. Objects constructed before an error or cancellation are kept in
+// the pool either way, unless the pool filled up in the meantime, in which
+// case they are passed to Destroy like any other object the pool can't hold.
+func (p *PoolErr[T, P]) PrewarmCtx(ctx context.Context, n int) error {
+	p.lock.Lock()
+	bestEffort := p.bestEffort
+	p.lock.Unlock()
+
+	var firstErr error
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.lock.Lock()
+		full := p.full()
+		p.lock.Unlock()
+		if full {
+			break
+		}
+
+		rv, err := p.New()
+		if err != nil {
+			if !bestEffort {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		p.Put(rv)
+	}
+	return firstErr
+}
+
+// Misses returns the number of times Get found the pool empty
+// and had to construct an object synchronously.
+func (p *PoolErr[T, P]) Misses() (misses uint64) {
+	p.lock.Lock()
+	misses = p.misses
+	p.lock.Unlock()
+	return
+}
+
+// Len returns the number of idle objects currently held by the pool.
+func (p *PoolErr[T, P]) Len() (n int) {
+	p.lock.Lock()
+	n = len(p.idle)
+	p.lock.Unlock()
+	return
+}
+
+func (p *PoolErr[T, P]) Put(value P) {
+	if value != nil {
+		value.Reset()
+		p.lock.Lock()
+		full := p.full()
+		if !full {
+			p.idle = append(p.idle, value)
+		}
+		p.lock.Unlock()
+		if full {
+			p.destroy(value)
+		}
+	}
+}
+
+// destroy is an internal function used to hand an object that the pool has
+// no room for to Destroy, if it is set.
+func (p *PoolErr[T, P]) destroy(value P) {
+	if p.Destroy != nil {
+		p.Destroy(value)
+	}
+}
+
+// Get returns an idle object from the pool, or constructs a new one if
+// the pool is empty. If construction fails the error is returned and
+// the pool is left unchanged.
+func (p *PoolErr[T, P]) Get() (P, error) {
+	p.lock.Lock()
+	rv, ok := p.pop()
+	if !ok {
+		p.misses++
+	}
+	if len(p.idle) == 0 && p.refill > 0 && !p.refilling {
+		p.refilling = true
+		go p.refillIdle()
+	}
+	p.lock.Unlock()
+	if ok {
+		return rv, nil
+	}
+
+	rv, err := p.New()
+	if err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// pop removes the most recently returned idle object from the pool,
+// and must be called with the lock held.
+func (p *PoolErr[T, P]) pop() (P, bool) {
+	n := len(p.idle)
+	if n == 0 {
+		return nil, false
+	}
+	rv := p.idle[n-1]
+	p.idle[n-1] = nil
+	p.idle = p.idle[:n-1]
+	return rv, true
+}
+
+// full returns true if the pool is holding the maximum number
+// of idle objects, and must be called with the lock held.
+func (p *PoolErr[T, P]) full() bool {
+	return p.maxIdle > 0 && len(p.idle) >= p.maxIdle
+}
+
+// refillIdle constructs objects until the pool holds the refill target,
+// stopping early if the pool reaches its maximum size or construction fails.
+func (p *PoolErr[T, P]) refillIdle() {
+	for {
+		p.lock.Lock()
+		if len(p.idle) >= p.refill || p.full() {
+			p.refilling = false
+			p.lock.Unlock()
+			return
+		}
+		p.lock.Unlock()
+
+		rv, err := p.New()
+
+		p.lock.Lock()
+		if err != nil {
+			p.refilling = false
+			p.lock.Unlock()
+			return
+		}
+		full := p.full()
+		if !full {
+			p.idle = append(p.idle, rv)
+		}
+		p.lock.Unlock()
+		if full {
+			// The pool filled up with objects that were Put while rv
+			// was being constructed, so there is no room left for it.
+			p.destroy(rv)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errConstruct = errors.New("construction failed")
+
+func TestPoolErr(t *testing.T) {
+	t.Run("Can Get() a constructed object", func(t *testing.T) {
+		p := NewPoolErr(func() (*demoData, error) {
+			return new(demoData), nil
+		})
+		d, err := p.Get()
+		require.NoError(t, err)
+		assert.NotNil(t, d)
+	})
+	t.Run("Can Put() and Get() the same object", func(t *testing.T) {
+		constructed := 0
+		p := NewPoolErr(func() (*demoData, error) {
+			constructed++
+			return new(demoData), nil
+		})
+		d, err := p.Get()
+		require.NoError(t, err)
+		d.Test = "Testing"
+		p.Put(d)
+
+		d2, err := p.Get()
+		require.NoError(t, err)
+		assert.Same(t, d, d2)
+		assert.Equal(t, "", d2.Test)
+		assert.Equal(t, 1, constructed)
+	})
+	t.Run("Can Put() nil", func(t *testing.T) {
+		p := NewPoolErr(func() (*demoData, error) {
+			return new(demoData), nil
+		})
+		p.Put(nil)
+		assert.Empty(t, p.idle)
+	})
+	t.Run("Get() propagates construction errors", func(t *testing.T) {
+		fail := true
+		p := NewPoolErr(func() (*demoData, error) {
+			if fail {
+				return new(demoData), errConstruct
+			}
+			return new(demoData), nil
+		})
+		d, err := p.Get()
+		assert.ErrorIs(t, err, errConstruct)
+		assert.Nil(t, d)
+		assert.Empty(t, p.idle)
+
+		fail = false
+		d, err = p.Get()
+		require.NoError(t, err)
+		assert.NotNil(t, d)
+	})
+	t.Run("Get() prefers idle objects over construction", func(t *testing.T) {
+		p := NewPoolErr(func() (*demoData, error) {
+			return nil, errConstruct
+		})
+		p.Put(new(demoData))
+		d, err := p.Get()
+		require.NoError(t, err)
+		assert.NotNil(t, d)
+
+		_, err = p.Get()
+		assert.ErrorIs(t, err, errConstruct)
+	})
+}
+
+func TestPoolErrMaxIdle(t *testing.T) {
+	p := NewPoolErr(func() (*demoData, error) {
+		return new(demoData), nil
+	})
+	p.SetMaxIdle(2)
+	for i := 0; i < 4; i++ {
+		p.Put(new(demoData))
+	}
+	assert.Len(t, p.idle, 2)
+
+	var destroyed []*demoData
+	p.Destroy = func(d *demoData) {
+		destroyed = append(destroyed, d)
+	}
+	d := new(demoData)
+	p.Put(d)
+	assert.Len(t, p.idle, 2)
+	assert.Equal(t, []*demoData{d}, destroyed)
+}
+
+func TestPoolErrLen(t *testing.T) {
+	p := NewPoolErr(func() (*demoData, error) {
+		return new(demoData), nil
+	})
+	assert.Equal(t, 0, p.Len())
+	for i := 0; i < 3; i++ {
+		p.Put(new(demoData))
+	}
+	assert.Equal(t, 3, p.Len())
+	_, err := p.Get()
+	require.NoError(t, err)
+	assert.Equal(t, 2, p.Len())
+
+	misses := p.Misses()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				d, err := p.Get()
+				if !assert.NoError(t, err) {
+					return
+				}
+				_ = p.Len()
+				p.Put(d)
+			}
+		}()
+	}
+	wg.Wait()
+	// Every object is returned, so the pool holds the two it started with
+	// plus one for every Get that found it empty and constructed a new one.
+	assert.Equal(t, 2+int(p.Misses()-misses), p.Len())
+}
+
+func TestPoolErrRefill(t *testing.T) {
+	burst := func(p *PoolErr[demoData, *demoData]) uint64 {
+		for round := 0; round < 10; round++ {
+			for i := 0; i < 10; i++ {
+				_, err := p.Get()
+				require.NoError(t, err)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		return p.Misses()
+	}
+
+	newDemoData := func() (*demoData, error) {
+		return new(demoData), nil
+	}
+
+	withoutRefill := NewPoolErr(newDemoData)
+	assert.Equal(t, uint64(100), burst(withoutRefill))
+
+	withRefill := NewPoolErr(newDemoData)
+	withRefill.SetRefill(10)
+	assert.Less(t, burst(withRefill), uint64(50))
+
+	capped := NewPoolErr(newDemoData)
+	capped.SetRefill(10)
+	capped.SetMaxIdle(4)
+	_, err := capped.Get()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		capped.lock.Lock()
+		defer capped.lock.Unlock()
+		return !capped.refilling
+	}, time.Second, time.Millisecond)
+	assert.Len(t, capped.idle, 4)
+
+	failing := NewPoolErr(func() (*demoData, error) {
+		return nil, errConstruct
+	})
+	failing.SetRefill(10)
+	_, err = failing.Get()
+	assert.ErrorIs(t, err, errConstruct)
+	require.Eventually(t, func() bool {
+		failing.lock.Lock()
+		defer failing.lock.Unlock()
+		return !failing.refilling
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, failing.idle)
+}
+
+func TestPoolErrPrewarmCtx(t *testing.T) {
+	// failAt returns a constructor that fails on the given calls.
+	failAt := func(calls ...int) func() (*demoData, error) {
+		n := 0
+		return func() (*demoData, error) {
+			n++
+			for _, call := range calls {
+				if n == call {
+					return nil, errConstruct
+				}
+			}
+			return new(demoData), nil
+		}
+	}
+
+	t.Run("prewarm", func(t *testing.T) {
+		p := NewPoolErr(failAt())
+		require.NoError(t, p.PrewarmCtx(context.Background(), 4))
+		assert.Equal(t, 4, p.Len())
+		_, err := p.Get()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), p.Misses())
+	})
+	t.Run("max idle", func(t *testing.T) {
+		p := NewPoolErr(failAt())
+		p.SetMaxIdle(2)
+		require.NoError(t, p.PrewarmCtx(context.Background(), 4))
+		assert.Equal(t, 2, p.Len())
+	})
+	t.Run("fail fast", func(t *testing.T) {
+		p := NewPoolErr(failAt(3))
+		assert.ErrorIs(t, p.PrewarmCtx(context.Background(), 5), errConstruct)
+		assert.Equal(t, 2, p.Len())
+	})
+	t.Run("best effort", func(t *testing.T) {
+		p := NewPoolErr(failAt(2, 4))
+		p.SetBestEffortPrewarm(true)
+		assert.ErrorIs(t, p.PrewarmCtx(context.Background(), 5), errConstruct)
+		assert.Equal(t, 3, p.Len())
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		n := 0
+		p := NewPoolErr(func() (*demoData, error) {
+			n++
+			if n == 2 {
+				cancel()
+			}
+			return new(demoData), nil
+		})
+		assert.ErrorIs(t, p.PrewarmCtx(ctx, 5), context.Canceled)
+		assert.Equal(t, 2, p.Len())
+	})
+}
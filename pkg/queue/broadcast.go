@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync"
+)
+
+// Broadcaster fans out every published element to all of its subscribers.
+//
+// Each subscriber has its own Circular queue and receives its own copy of
+// every element published after it subscribed. A subscriber created with the
+// DropOldest overflow policy never stalls the publisher, while one created with
+// the Block policy applies backpressure to it when its queue is full.
+type Broadcaster[T any, P Pointer[T]] struct {
+	lock        sync.Mutex
+	subscribers map[*Subscriber[T, P]]struct{}
+	closed      bool
+}
+
+// Subscriber receives the elements published by a Broadcaster.
+type Subscriber[T any, P Pointer[T]] struct {
+	queue *Circular[T, P]
+}
+
+// NewBroadcaster creates a new broadcaster with no subscribers.
+func NewBroadcaster[T any, P Pointer[T]]() *Broadcaster[T, P] {
+	return &Broadcaster[T, P]{
+		subscribers: make(map[*Subscriber[T, P]]struct{}),
+	}
+}
+
+// Subscribe creates a new subscriber whose queue has the given size and
+// overflow policy. It returns Closed if the broadcaster is closed.
+func (b *Broadcaster[T, P]) Subscribe(maxSize uint64, overflow Overflow) (*Subscriber[T, P], error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return nil, Closed
+	}
+	s := &Subscriber[T, P]{
+		queue: NewCircularWithOverflow[T, P](maxSize, overflow),
+	}
+	b.subscribers[s] = struct{}{}
+	return s, nil
+}
+
+// Unsubscribe removes s from the broadcaster and closes its queue, waking up
+// any blocked Pop calls on it. Elements already in its queue can still be
+// retrieved with Drain.
+func (b *Broadcaster[T, P]) Unsubscribe(s *Subscriber[T, P]) {
+	b.lock.Lock()
+	delete(b.subscribers, s)
+	b.lock.Unlock()
+	s.queue.Close()
+}
+
+// Publish pushes a copy of p to every subscriber. It blocks while a subscriber
+// using the Block overflow policy is full, and returns Closed if the
+// broadcaster is closed. A nil p has nothing to copy, so every subscriber
+// receives nil.
+func (b *Broadcaster[T, P]) Publish(p P) error {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return Closed
+	}
+	subscribers := make([]*Subscriber[T, P], 0, len(b.subscribers))
+	for s := range b.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	b.lock.Unlock()
+
+	for _, s := range subscribers {
+		var c P
+		if p != nil {
+			c = P(new(T))
+			*c = *p
+		}
+		// The only error Push can return is Closed, which means the
+		// subscriber was removed while we were publishing.
+		_ = s.queue.Push(c)
+	}
+	return nil
+}
+
+// Subscribers returns the number of subscribers.
+func (b *Broadcaster[T, P]) Subscribers() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subscribers)
+}
+
+// IsClosed returns true if the broadcaster is closed.
+func (b *Broadcaster[T, P]) IsClosed() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.closed
+}
+
+// Close closes the broadcaster permanently and unsubscribes all of its
+// subscribers. Like Circular.Close, it returns true if this call closed the
+// broadcaster.
+func (b *Broadcaster[T, P]) Close() (closed bool) {
+	b.lock.Lock()
+	closed = !b.closed
+	b.closed = true
+	subscribers := b.subscribers
+	b.subscribers = make(map[*Subscriber[T, P]]struct{})
+	b.lock.Unlock()
+	for s := range subscribers {
+		s.queue.Close()
+	}
+	return
+}
+
+// Pop removes and returns the next element published to the subscriber,
+// blocking until one is available. It returns Closed once the subscriber has
+// been unsubscribed.
+func (s *Subscriber[T, P]) Pop() (P, error) {
+	return s.queue.Pop()
+}
+
+// Length returns the number of elements waiting to be popped.
+func (s *Subscriber[T, P]) Length() int {
+	return s.queue.Length()
+}
+
+// Drain removes and returns all the elements waiting to be popped.
+func (s *Subscriber[T, P]) Drain() []P {
+	return s.queue.Drain()
+}
+
+// IsClosed returns true if the subscriber has been unsubscribed.
+func (s *Subscriber[T, P]) IsClosed() bool {
+	return s.queue.IsClosed()
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcaster(t *testing.T) {
+	t.Parallel()
+
+	t.Run("every subscriber gets a copy", func(t *testing.T) {
+		b := NewBroadcaster[P, *P]()
+		s1, err := b.Subscribe(4, Block)
+		require.NoError(t, err)
+		s2, err := b.Subscribe(4, Block)
+		require.NoError(t, err)
+		assert.Equal(t, 2, b.Subscribers())
+
+		p := &P{Int: 1, String: "one"}
+		require.NoError(t, b.Publish(p))
+
+		a1, err := s1.Pop()
+		require.NoError(t, err)
+		a2, err := s2.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, *p, *a1)
+		assert.Equal(t, *p, *a2)
+		assert.NotSame(t, p, a1)
+		assert.NotSame(t, a1, a2)
+	})
+	t.Run("nil", func(t *testing.T) {
+		b := NewBroadcaster[P, *P]()
+		s1, err := b.Subscribe(4, Block)
+		require.NoError(t, err)
+		s2, err := b.Subscribe(4, Block)
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish(nil))
+		for _, s := range []*Subscriber[P, *P]{s1, s2} {
+			actual, err := s.Pop()
+			require.NoError(t, err)
+			assert.Nil(t, actual)
+		}
+	})
+	t.Run("slow subscriber drops", func(t *testing.T) {
+		b := NewBroadcaster[P, *P]()
+		slow, err := b.Subscribe(2, DropOldest)
+		require.NoError(t, err)
+		fast, err := b.Subscribe(8, Block)
+		require.NoError(t, err)
+
+		for i := 1; i <= 5; i++ {
+			require.NoError(t, b.Publish(&P{Int: i}))
+		}
+		assert.Equal(t, 5, fast.Length())
+
+		n := slow.Length()
+		require.Less(t, n, 5)
+		for i := 6 - n; i <= 5; i++ {
+			actual, err := slow.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+	})
+	t.Run("unsubscribe", func(t *testing.T) {
+		b := NewBroadcaster[P, *P]()
+		s, err := b.Subscribe(1, Block)
+		require.NoError(t, err)
+		require.NoError(t, b.Publish(&P{Int: 1}))
+
+		done := make(chan error, 1)
+		go func() {
+			// Blocks because the subscriber is full.
+			done <- b.Publish(&P{Int: 2})
+		}()
+		select {
+		case <-done:
+			t.Fatal("publish should block on a full subscriber")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		b.Unsubscribe(s)
+		require.NoError(t, <-done)
+		assert.Equal(t, 0, b.Subscribers())
+		assert.True(t, s.IsClosed())
+		_, err = s.Pop()
+		assert.ErrorIs(t, err, Closed)
+		assert.Len(t, s.Drain(), 1)
+	})
+	t.Run("close", func(t *testing.T) {
+		b := NewBroadcaster[P, *P]()
+		s, err := b.Subscribe(1, Block)
+		require.NoError(t, err)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.Pop()
+			done <- err
+		}()
+		b.Close()
+		assert.ErrorIs(t, <-done, Closed)
+		assert.True(t, b.IsClosed())
+		assert.ErrorIs(t, b.Publish(&P{}), Closed)
+		_, err = b.Subscribe(1, Block)
+		assert.ErrorIs(t, err, Closed)
+	})
+}
@@ -0,0 +1,9 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !arm64 && !ppc64 && !ppc64le
+
+package queue
+
+// cacheLinePadding is the number of uint64 values needed to
+// fill a 64-byte cache line.
+const cacheLinePadding = 8
@@ -3,7 +3,11 @@
 package queue
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 // Circular is a circular sized FIFO queue that uses
@@ -12,45 +16,193 @@ import (
 // It is thread safe and extremely performant, however
 // it is a blocking queue and will block the caller
 // if the queue is full or if it is empty.
+//
+// Goroutines that are blocked waiting for an element are served in the order
+// they blocked, so the consumer that has waited the longest receives the next
+// element. A caller that finds an element available without having to wait
+// may still take it ahead of the blocked consumers.
+//
+// The head and tail, and each group of fields that is hot for producers or
+// for consumers, are padded onto separate cache lines so that producers and
+// consumers do not contend on the same line (false sharing).
 type Circular[T any, P Pointer[T]] struct {
-	_padding0 [8]uint64 //nolint:structcheck,unused
-	head      uint64
-	_padding1 [8]uint64 //nolint:structcheck,unused
-	tail      uint64
-	_padding2 [8]uint64 //nolint:structcheck,unused
-	maxSize   uint64
-	_padding3 [8]uint64 //nolint:structcheck,unused
-	closed    bool
-	_padding4 [8]uint64 //nolint:structcheck,unused
-	lock      *sync.Mutex
-	_padding5 [8]uint64 //nolint:structcheck,unused
-	notEmpty  *sync.Cond
-	_padding6 [8]uint64 //nolint:structcheck,unused
-	notFull   *sync.Cond
-	_padding7 [8]uint64 //nolint:structcheck,unused
-	nodes     []P
+	_padding0   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	head        uint64
+	_padding1   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	tail        uint64
+	_padding2   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	maxSize     uint64
+	overflow    Overflow
+	maxSlots    uint64
+	minSlots    uint64
+	capSlots    uint64
+	exact       bool
+	rejectNil   bool
+	_padding3   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	closed      bool
+	err         error
+	c           chan P
+	done        chan struct{}
+	fed         chan struct{}
+	closing     chan struct{}
+	_padding4   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	lock        *sync.Mutex
+	_padding5   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	notEmpty    *notifier
+	_padding6   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	notFull     *notifier
+	noWaiters   *notifier
+	pushWaiters int64
+	popWaiters  int64
+	popWaking   bool
+	maxWaiters  int64
+	_padding7   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	nodes       []P
+	_padding8   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	size        uint64
+	_padding9   [cacheLinePadding]uint64 //nolint:structcheck,unused
+	skip        func(P) bool
+	onPush      func(P)
+	onPop       func(P)
+	_padding10  [cacheLinePadding]uint64 //nolint:structcheck,unused
+	stats       counters
+}
+
+// Stats holds cumulative counters that describe the activity of a Circular queue.
+type Stats struct {
+	// Pushes is the number of elements that have been added to the queue.
+	Pushes uint64
+
+	// Pops is the number of elements that have been removed from the queue,
+	// not counting elements that were discarded by the skip predicate.
+	Pops uint64
+
+	// PushBlocks is the number of push calls that had to wait for space.
+	PushBlocks uint64
+
+	// PopBlocks is the number of pop calls that had to wait for an element.
+	PopBlocks uint64
+
+	// Skipped is the number of elements discarded by the skip predicate.
+	Skipped uint64
+
+	// PeakLength is the highest number of elements that the queue has held.
+	PeakLength int
+}
+
+// counters holds the statistics counters of a Circular queue, which are only
+// written with the queue's lock held but can be read atomically without it.
+type counters struct {
+	pushes     uint64
+	pops       uint64
+	pushBlocks uint64
+	popBlocks  uint64
+	skipped    uint64
+	peakLength uint64
 }
 
+// Overflow is the policy a Circular queue follows when
+// an element is pushed while the queue is full.
+type Overflow int
+
+const (
+	// Block blocks the caller until space becomes available in the queue.
+	Block Overflow = iota
+
+	// DropOldest evicts the element at the head of the queue to make room,
+	// so pushing never blocks.
+	DropOldest
+
+	// Grow doubles the size of the queue to make room, up to the maximum
+	// capacity of the queue. Once the queue has reached its maximum
+	// capacity, pushing blocks as it does with Block.
+	Grow
+)
+
 // NewCircular creates a new circular queue with the given size.
 func NewCircular[T any, P Pointer[T]](maxSize uint64) *Circular[T, P] {
+	return NewCircularWithOverflow[T, P](maxSize, Block)
+}
+
+// NewCircularWithOverflow creates a new circular queue with the given size
+// that follows the given overflow policy when it is full.
+func NewCircularWithOverflow[T any, P Pointer[T]](maxSize uint64, overflow Overflow) *Circular[T, P] {
+	return newCircular[T, P](&options{capacity: maxSize, overflow: overflow})
+}
+
+// NewCircularOverwrite creates a new lossy ring buffer that holds exactly
+// capacity elements. Once it is full, pushing overwrites the oldest element
+// instead of blocking, following the DropOldest overflow policy, so the queue
+// always holds the most recently pushed elements. Use PushEvict to receive the
+// element that was overwritten.
+func NewCircularOverwrite[T any, P Pointer[T]](capacity uint64) *Circular[T, P] {
+	return newCircular[T, P](&options{capacity: capacity, overflow: DropOldest, exact: true})
+}
+
+// NewCircularOpts creates a new circular queue configured with the given options.
+//
+// An error is returned if the options are invalid or conflict with each other.
+func NewCircularOpts[T any, P Pointer[T]](opts ...Option) (*Circular[T, P], error) {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return newCircular[T, P](o), nil
+}
+
+// newCircular is an internal function used to create a new circular
+// queue from a validated set of options.
+func newCircular[T any, P Pointer[T]](o *options) *Circular[T, P] {
 	q := new(Circular[T, P])
+	q.overflow = o.overflow
+	q.rejectNil = o.rejectNil
+	q.maxWaiters = int64(o.maxWaiters)
 	q.lock = new(sync.Mutex)
-	q.notFull = sync.NewCond(q.lock)
-	q.notEmpty = sync.NewCond(q.lock)
+	q.notFull = newNotifier(q.lock)
+	q.notEmpty = newNotifier(q.lock)
+	q.noWaiters = newNotifier(q.lock)
 
 	q.head = 0
 	q.tail = 0
-	maxSize++
-	if maxSize < 2 {
-		q.maxSize = 2
-	} else {
-		q.maxSize = round(maxSize)
+	q.exact = o.exact
+	q.maxSize = q.slotsFor(o.capacity)
+	q.minSlots = q.maxSize
+	if o.maxCapacity > 0 {
+		q.maxSlots = slots(o.maxCapacity)
 	}
 
-	q.nodes = make([]P, q.maxSize)
+	if !o.lazy {
+		q.nodes = make([]P, q.maxSize)
+	}
 	return q
 }
 
+// slots is an internal function used to get the size of the backing
+// array needed to hold the given number of elements.
+func slots(capacity uint64) uint64 {
+	capacity++
+	if capacity < 2 {
+		return 2
+	}
+	return round(capacity)
+}
+
+// slotsFor is an internal function used to get the size of the backing array
+// this queue needs to hold the given number of elements, which is exact for
+// queues created with NewCircularOverwrite.
+func (q *Circular[T, P]) slotsFor(capacity uint64) uint64 {
+	if !q.exact {
+		return slots(capacity)
+	}
+	if capacity < 1 {
+		return 2
+	}
+	return capacity + 1
+}
+
 // IsEmpty returns true if the queue is empty.
 func (q *Circular[T, P]) IsEmpty() (empty bool) {
 	q.lock.Lock()
@@ -76,9 +228,22 @@ func (q *Circular[T, P]) IsFull() (full bool) {
 // isFull is an internal function used to check if the
 // queue is full.
 func (q *Circular[T, P]) isFull() bool {
+	if q.capSlots > 0 {
+		return uint64(q.length()) >= q.capSlots-1
+	}
 	return q.head == (q.tail+1)%q.maxSize
 }
 
+// capacity is an internal function used to get the number of elements the
+// queue can hold before it is full. While requeue has grown the backing array
+// past the queue's capacity, this is less than the size of the array.
+func (q *Circular[T, P]) capacity() uint64 {
+	if q.capSlots > 0 {
+		return q.capSlots - 1
+	}
+	return q.maxSize - 1
+}
+
 // IsClosed returns true if the queue is Closed
 //
 // The Drain method can be used to drain the queue after it is closed.
@@ -111,76 +276,1883 @@ func (q *Circular[T, P]) length() int {
 	return int(q.tail - q.head)
 }
 
-// Close closes the queue permanently.
+// Cap returns the number of elements the queue can hold before it is full.
+//
+// This is at least the size the queue was created with, but can be larger,
+// since the queue's backing array is rounded up to a power of two. For queues
+// that use the Grow overflow policy, it is the current capacity.
+func (q *Circular[T, P]) Cap() (capacity int) {
+	q.lock.Lock()
+	capacity = int(q.capacity())
+	q.lock.Unlock()
+	return
+}
+
+// LengthApprox returns the number of elements in the queue without
+// acquiring the queue's lock, which makes it suitable for monitoring code
+// that samples the length at a high frequency.
+//
+// The value is published atomically at the end of every operation that
+// changes the length of the queue, so it is never torn and always equals the
+// exact length of the queue at some recent point in time. It does not
+// reflect operations that are still in progress, so it can briefly lag
+// behind Length when there are concurrent producers or consumers.
+func (q *Circular[T, P]) LengthApprox() int {
+	return int(atomic.LoadUint64(&q.size))
+}
+
+// updateSize is an internal function used to publish the current length of
+// the queue for LengthApprox. It must be called with the lock held after
+// every change to head or tail.
+//
+// It also shrinks the backing array back to the queue's capacity once the
+// elements that requeue put back past it have been popped.
+func (q *Circular[T, P]) updateSize() {
+	length := uint64(q.length())
+	if q.capSlots > 0 && length < q.capSlots {
+		q.resize(q.capSlots)
+		q.capSlots = 0
+	}
+	if length > atomic.LoadUint64(&q.stats.peakLength) {
+		atomic.StoreUint64(&q.stats.peakLength, length)
+	}
+	atomic.StoreUint64(&q.size, length)
+}
+
+// Stats returns a copy of the queue's cumulative statistics counters.
+//
+// The counters are read atomically without acquiring the queue's lock, so
+// Stats is cheap enough to call from a metrics exporter at any frequency.
+// Each counter is read separately, so the counters can be off from each other
+// by the operations that were in progress while Stats was called.
+func (q *Circular[T, P]) Stats() Stats {
+	return Stats{
+		Pushes:     atomic.LoadUint64(&q.stats.pushes),
+		Pops:       atomic.LoadUint64(&q.stats.pops),
+		PushBlocks: atomic.LoadUint64(&q.stats.pushBlocks),
+		PopBlocks:  atomic.LoadUint64(&q.stats.popBlocks),
+		Skipped:    atomic.LoadUint64(&q.stats.skipped),
+		PeakLength: int(atomic.LoadUint64(&q.stats.peakLength)),
+	}
+}
+
+// ResetStats zeroes the queue's cumulative statistics counters, which is useful
+// for exporting per-interval rates instead of totals. The contents of the queue
+// are not affected, and PeakLength is reset to the current length of the queue.
+func (q *Circular[T, P]) ResetStats() {
+	q.lock.Lock()
+	atomic.StoreUint64(&q.stats.pushes, 0)
+	atomic.StoreUint64(&q.stats.pops, 0)
+	atomic.StoreUint64(&q.stats.pushBlocks, 0)
+	atomic.StoreUint64(&q.stats.popBlocks, 0)
+	atomic.StoreUint64(&q.stats.skipped, 0)
+	atomic.StoreUint64(&q.stats.peakLength, uint64(q.length()))
+	q.lock.Unlock()
+}
+
+// HighWaterMark returns the highest number of elements that the queue has held
+// since it was created or the mark was last reset, which is the same as the
+// PeakLength reported by Stats. It is read atomically without acquiring the
+// queue's lock.
+func (q *Circular[T, P]) HighWaterMark() int {
+	return int(atomic.LoadUint64(&q.stats.peakLength))
+}
+
+// ResetHighWaterMark resets the high-water mark to the current length of the
+// queue, leaving the other statistics counters untouched.
+func (q *Circular[T, P]) ResetHighWaterMark() {
+	q.lock.Lock()
+	atomic.StoreUint64(&q.stats.peakLength, uint64(q.length()))
+	q.lock.Unlock()
+}
+
+// WithSlices calls f with the elements currently in the queue, in FIFO order,
+// without copying them. Because the queue wraps around its backing array the
+// elements are split into up to two contiguous slices: first holds the elements
+// from the head up to the end of the array, and second holds the wrapped
+// elements from the start of the array up to the tail. If the elements do not
+// wrap, second is empty.
+//
+// The slices alias the queue's backing array and are only valid for the
+// duration of f. The queue's lock is held while f runs, so f must not
+// modify the slices or call any other method on the queue.
+func (q *Circular[T, P]) WithSlices(f func(first []P, second []P)) {
+	q.lock.Lock()
+	first, second := q.slices()
+	f(first, second)
+	q.lock.Unlock()
+}
+
+// slices is an internal function used to get the (up to two) contiguous
+// slices of the backing array that hold the elements in the queue.
+func (q *Circular[T, P]) slices() (first []P, second []P) {
+	if q.tail < q.head {
+		return q.nodes[q.head:], q.nodes[:q.tail]
+	}
+	return q.nodes[q.head:q.tail], nil
+}
+
+// Close closes the queue permanently. Every goroutine blocked in a push or
+// pop is woken up and returns Closed, as does every later call.
+//
+// Close returns true if this call closed the queue, and false if the queue
+// was already closed, so that when several goroutines race to close the queue
+// exactly one of them learns that it owns the shutdown.
 //
 // The Drain method can be used to drain the queue after it is closed.
-func (q *Circular[T, P]) Close() {
+func (q *Circular[T, P]) Close() bool {
+	return q.CloseWithCause(nil)
+}
+
+// CloseWithCause closes the queue permanently, recording why it was closed.
+//
+// Once the queue is closed, blocked and future calls return an error that
+// matches Closed with errors.Is and unwraps to cause. A nil cause is the same
+// as calling Close, and the bare Closed error is returned instead. Closing an
+// already closed queue is a no-op that keeps the original cause, and returns
+// false like Close does.
+func (q *Circular[T, P]) CloseWithCause(cause error) (closed bool) {
+	q.lock.Lock()
+	closed = q.close(cause)
+	q.lock.Unlock()
+	return
+}
+
+// CloseWait closes the queue like Close, then blocks until every goroutine
+// that was blocked in the queue has woken up and observed that it is closed.
+//
+// Once CloseWait returns, no call that was waiting when the queue was closed
+// can still add or remove an element.
+func (q *Circular[T, P]) CloseWait() {
+	q.lock.Lock()
+	q.close(nil)
+	for atomic.LoadInt64(&q.pushWaiters) > 0 || atomic.LoadInt64(&q.popWaiters) > 0 {
+		q.noWaiters.Wait()
+	}
+	q.lock.Unlock()
+}
+
+// CloseAndDrain closes the queue and drains it in one step, returning the
+// elements left in it in FIFO order. Since both happen while holding the
+// queue's lock, no element can be pushed in between and get lost.
+func (q *Circular[T, P]) CloseAndDrain() []P {
+	q.lock.Lock()
+	q.close(nil)
+	values := q.drain()
+	q.lock.Unlock()
+	return values
+}
+
+// Reset empties the queue and reopens it if it was closed, so that it can be
+// reused, for example from a pool, without reallocating its backing array.
+// The elements left in the queue are dropped, and its statistics are kept;
+// ResetStats can be used to clear them as well.
+//
+// If C has been called, Reset closes the queue, which closes the channel
+// returned by C, and waits for the goroutine feeding it to exit before
+// reopening the queue. A later call to C returns a new channel.
+//
+// Reset must only be called when no goroutine is blocked on the queue or
+// receiving from the channel returned by C, and it panics if any goroutine
+// is still waiting for space or for an element.
+func (q *Circular[T, P]) Reset() {
 	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.c != nil {
+		// The goroutine feeding C is a pop waiter for as long as the queue is
+		// open, and it only exits once the queue is closed.
+		q.close(nil)
+		fed := q.fed
+		q.lock.Unlock()
+		<-fed
+		q.lock.Lock()
+	}
+	if atomic.LoadInt64(&q.pushWaiters) > 0 || atomic.LoadInt64(&q.popWaiters) > 0 {
+		panic("queue: reset with blocked waiters")
+	}
+	for i := range q.nodes {
+		q.nodes[i] = nil
+	}
+	q.head = 0
+	q.tail = 0
+	q.updateSize()
+	q.closed = false
+	q.err = nil
+	q.popWaking = false
+	q.c = nil
+	q.done = nil
+	q.fed = nil
+	q.closing = nil
+}
+
+// close is an internal function used to close the queue, unless it is
+// already closed, and wake up every blocked caller. It returns false if the
+// queue was already closed.
+func (q *Circular[T, P]) close(cause error) bool {
+	if q.closed {
+		return false
+	}
 	q.closed = true
+	q.err = closedErr(cause)
 	q.notFull.Broadcast()
 	q.notEmpty.Broadcast()
+	if q.done != nil {
+		close(q.done)
+	}
+	if q.closing != nil {
+		close(q.closing)
+	}
+	return true
+}
+
+// closedCh is an internal function used to get a channel that is closed once the
+// queue is closed, so that a wait on another queue can stop when this one is
+// closed. It must be called with the lock held.
+func (q *Circular[T, P]) closedCh() <-chan struct{} {
+	if q.closing == nil {
+		q.closing = make(chan struct{})
+		if q.closed {
+			close(q.closing)
+		}
+	}
+	return q.closing
+}
+
+// C returns a channel that receives the elements of the queue, in FIFO order,
+// for using the queue in a select statement. The channel is fed by a goroutine
+// that is started by the first call to C and pops elements from the queue as
+// the channel's receivers take them, so it never spins while the queue is
+// empty. Every call to C returns the same channel.
+//
+// The channel is closed once the queue is closed. An element that the goroutine
+// popped but no receiver took before the queue was closed is put back at the
+// head of the queue, so Drain can still recover it.
+//
+// Receiving from C while also calling Pop or any other method that removes
+// elements has undefined results, since the goroutine and the other callers
+// compete for the same elements.
+func (q *Circular[T, P]) C() <-chan P {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.c == nil {
+		q.c = make(chan P)
+		q.done = make(chan struct{})
+		q.fed = make(chan struct{})
+		if q.closed {
+			close(q.done)
+		}
+		go q.feed(q.c, q.done, q.fed)
+	}
+	return q.c
+}
+
+// feed is an internal function used to move elements from the queue to the
+// channel returned by C, until the queue is closed. The channels are passed
+// in rather than read from the queue, since Reset clears them, and fed is
+// closed once feed has exited.
+func (q *Circular[T, P]) feed(c chan P, done chan struct{}, fed chan struct{}) {
+	defer close(fed)
+	defer close(c)
+	for {
+		p, err := q.Pop()
+		if err != nil {
+			return
+		}
+		select {
+		case c <- p:
+		case <-done:
+			q.requeue(p)
+			return
+		}
+	}
+}
+
+// Subscribe returns a channel that receives elements popped from the queue,
+// along with a function that cancels the subscription. Like C, the channel is
+// fed by a goroutine, but every call to Subscribe starts its own goroutine with
+// its own channel, buffered with bufSize elements, so several subscribers can
+// consume from the queue at once, each receiving a disjoint subset of its
+// elements, just like concurrent callers of Pop do.
+//
+// The channel is closed once the queue is closed, after every element that was
+// popped for it has been received. Calling cancel stops the goroutine, waits
+// for it to exit and closes the channel. Elements that were popped for the
+// subscriber but not yet received, including any left in the channel's buffer,
+// are put back at the head of the queue in order, so nothing is lost. If the
+// queue filled up in the meantime, it holds more than Cap elements until they
+// are popped, without its capacity changing. The subscriber must not receive
+// from the channel while cancel runs, and calling cancel more than once is a
+// no-op.
+func (q *Circular[T, P]) Subscribe(bufSize int) (<-chan P, func()) {
+	if bufSize < 0 {
+		bufSize = 0
+	}
+	ch := make(chan P, bufSize)
+	ctx, stop := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		defer close(ch)
+		for {
+			p, err := q.PopContext(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					q.requeue(unsent(ch)...)
+				}
+				return
+			}
+			select {
+			case ch <- p:
+			case <-ctx.Done():
+				q.requeue(append(unsent(ch), p)...)
+				return
+			}
+		}
+	}()
+	return ch, func() {
+		stop()
+		<-exited
+	}
+}
+
+// unsent is an internal function used to take back the elements left in the
+// buffer of a subscriber's channel.
+func unsent[P any](ch chan P) (values []P) {
+	for len(ch) > 0 {
+		values = append(values, <-ch)
+	}
+	return
+}
+
+// requeue is an internal function used to put elements that were popped but
+// never delivered back at the head of the queue, in order.
+//
+// If the queue has filled up in the meantime, its backing array is grown to
+// make room, since the elements can't be dropped and requeue must not block.
+// The growth is only temporary: the queue's capacity is unchanged, so it stays
+// full, and the backing array shrinks back once enough elements are popped.
+func (q *Circular[T, P]) requeue(values ...P) {
+	if len(values) == 0 {
+		return
+	}
+	q.lock.Lock()
+	for i := len(values) - 1; i >= 0; i-- {
+		if q.head == (q.tail+1)%q.maxSize {
+			if q.capSlots == 0 {
+				q.capSlots = q.maxSize
+			}
+			q.resize(q.maxSize * 2)
+		}
+		q.pushFront(values[i])
+	}
 	q.lock.Unlock()
 }
 
-// Push adds an element to the queue.
-func (q *Circular[T, P]) Push(p P) error {
+// WaiterCount returns the number of goroutines that are currently
+// blocked waiting for space or for an element in the queue.
+//
+// It is meant for debugging and monitoring, for example to check that
+// waiters do not accumulate when a queue is closed.
+func (q *Circular[T, P]) WaiterCount() (count int) {
+	q.lock.Lock()
+	count = int(atomic.LoadInt64(&q.pushWaiters) + atomic.LoadInt64(&q.popWaiters))
+	q.lock.Unlock()
+	return
+}
+
+// WaitingPushers returns the number of goroutines that are currently blocked
+// waiting for space in the queue. Together with WaitingPoppers, it tells
+// starved producers apart from starved consumers when debugging deadlocks.
+//
+// The count is read atomically without acquiring the queue's lock, so it can
+// be sampled even while the queue is deadlocked.
+func (q *Circular[T, P]) WaitingPushers() int {
+	return int(atomic.LoadInt64(&q.pushWaiters))
+}
+
+// WaitingPoppers returns the number of goroutines that are currently blocked
+// waiting for an element in the queue. Like WaitingPushers, it never acquires
+// the queue's lock.
+func (q *Circular[T, P]) WaitingPoppers() int {
+	return int(atomic.LoadInt64(&q.popWaiters))
+}
+
+// waitNotFull is an internal function used to wait for space to become
+// available in the queue, keeping track of the number of waiters. It returns
+// ErrTooManyWaiters without waiting if the queue's limit on the number of
+// waiters has been reached.
+func (q *Circular[T, P]) waitNotFull() error {
+	return q.waitNotFullContext(context.Background())
+}
+
+// waitNotFullContext is like waitNotFull, but stops waiting and returns the
+// context's error once ctx is done.
+func (q *Circular[T, P]) waitNotFullContext(ctx context.Context) error {
+	if q.maxWaiters > 0 && atomic.LoadInt64(&q.pushWaiters) >= q.maxWaiters {
+		return ErrTooManyWaiters
+	}
+	atomic.AddInt64(&q.pushWaiters, 1)
+	err := q.notFull.WaitContext(ctx)
+	if atomic.AddInt64(&q.pushWaiters, -1) < 0 {
+		panic("queue: negative push waiter count")
+	}
+	q.waiterDone()
+	return err
+}
+
+// waitNotEmpty is an internal function used to wait for an element to become
+// available in the queue, keeping track of the number of waiters. Like
+// waitNotFull, it returns ErrTooManyWaiters if there are too many waiters.
+func (q *Circular[T, P]) waitNotEmpty() error {
+	return q.waitNotEmptyContext(context.Background())
+}
+
+// waitNotEmptyContext is like waitNotEmpty, but stops waiting and returns the
+// context's error once ctx is done.
+//
+// Waiters are served in the order they started waiting: only the waiter that
+// has been waiting the longest is woken up for a new element, and a waiter
+// that finds the element already taken when it wakes up, for example by a
+// caller that never had to wait, goes back to waiting ahead of the others.
+// When a waiter wakes up to more than one element, it wakes up the next one.
+func (q *Circular[T, P]) waitNotEmptyContext(ctx context.Context) error {
+	if q.maxWaiters > 0 && atomic.LoadInt64(&q.popWaiters) >= q.maxWaiters {
+		return ErrTooManyWaiters
+	}
+	return q.waitNotEmptyUnlimited(ctx)
+}
+
+// waitNotEmptyUnlimited is like waitNotEmptyContext, but ignores the queue's
+// limit on the number of waiters.
+func (q *Circular[T, P]) waitNotEmptyUnlimited(ctx context.Context) error {
+	atomic.AddInt64(&q.popWaiters, 1)
+	err := q.notEmpty.WaitContext(ctx)
+	for err == nil {
+		q.popWaking = false
+		if q.isClosed() {
+			break
+		}
+		q.discard()
+		if !q.isEmpty() {
+			if q.length() > 1 {
+				q.signalNotEmpty()
+			}
+			break
+		}
+		err = q.notEmpty.WaitFirstContext(ctx)
+	}
+	if atomic.AddInt64(&q.popWaiters, -1) < 0 {
+		panic("queue: negative pop waiter count")
+	}
+	q.waiterDone()
+	return err
+}
+
+// signalNotEmpty is an internal function used to wake up the goroutine that
+// has been waiting the longest for an element, unless a woken waiter has yet
+// to take one, so that waiters can't overtake each other.
+func (q *Circular[T, P]) signalNotEmpty() {
+	if !q.popWaking && q.notEmpty.Signal() {
+		q.popWaking = true
+	}
+}
+
+// waiterDone is an internal function used to wake up CloseWait once the last
+// waiter has stopped waiting.
+func (q *Circular[T, P]) waiterDone() {
+	if atomic.LoadInt64(&q.pushWaiters) == 0 && atomic.LoadInt64(&q.popWaiters) == 0 {
+		q.noWaiters.Broadcast()
+	}
+}
+
+// WaitUnlock blocks until the queue has at least one element or is closed,
+// and is meant for composing the queue with a caller's own locking.
+//
+// The caller must hold its own lock when calling WaitUnlock, and pass a function
+// that releases it. That function is called once the queue's lock has been
+// acquired, so an element pushed after the caller last inspected its own state
+// can't be missed. The caller's lock is not reacquired when WaitUnlock returns.
+//
+// The unlock function is called with the queue's lock held, so it must not call
+// any method on the queue, and the caller's lock must never be acquired while
+// holding the queue's lock or deadlocks are possible. An element that is
+// available when WaitUnlock returns may still be taken by another consumer
+// before the caller pops it.
+func (q *Circular[T, P]) WaitUnlock(unlock func()) error {
 	q.lock.Lock()
+	unlock()
 LOOP:
 	if q.isClosed() {
+		err := q.err
 		q.lock.Unlock()
-		return Closed
+		return err
 	}
-	if q.isFull() {
-		q.notFull.Wait()
+	q.discard()
+	if q.isEmpty() {
+		if err := q.waitNotEmpty(); err != nil {
+			q.lock.Unlock()
+			return err
+		}
 		goto LOOP
 	}
-
-	q.nodes[q.tail] = p
-	q.tail = (q.tail + 1) % q.maxSize
-	q.notEmpty.Signal()
+	// The caller pops the element, if at all, only after the queue's lock is
+	// released, so pass on a wakeup that may have been meant for a blocked Pop.
+	q.signalNotEmpty()
 	q.lock.Unlock()
 	return nil
 }
 
-// Pop removes an element from the queue.
-func (q *Circular[T, P]) Pop() (p P, err error) {
+// WaitNonEmpty blocks until the queue has at least one element, without
+// removing it, so that the caller can then take a batch of elements with
+// PopBatchInto or DrainN. It returns early if ctx is done or the queue is
+// closed, with the context's error or the same error as Pop respectively.
+//
+// A wakeup for an element that another consumer takes first is ignored and
+// WaitNonEmpty keeps waiting. An element that is available when WaitNonEmpty
+// returns may still be taken by another consumer before the caller gets to it.
+func (q *Circular[T, P]) WaitNonEmpty(ctx context.Context) error {
 	q.lock.Lock()
 LOOP:
 	if q.isClosed() {
+		err := q.err
 		q.lock.Unlock()
-		return nil, Closed
+		return err
 	}
+	q.discard()
 	if q.isEmpty() {
-		q.notEmpty.Wait()
+		if err := q.waitNotEmptyContext(ctx); err != nil {
+			q.lock.Unlock()
+			return err
+		}
 		goto LOOP
 	}
+	// We may have consumed the wakeup meant for a blocked Pop, so pass it on
+	// in case the caller doesn't pop the element itself.
+	q.signalNotEmpty()
+	q.lock.Unlock()
+	return nil
+}
 
-	p = q.nodes[q.head]
-	q.head = (q.head + 1) % q.maxSize
-	q.notFull.Signal()
+// Consumer returns a view of the queue that can only be used to
+// pop elements. It is backed by the same underlying queue.
+func (q *Circular[T, P]) Consumer() Consumer[T, P] {
+	return q
+}
+
+// Producer returns a view of the queue that can only be used to
+// push elements and close the queue. It is backed by the same underlying queue.
+func (q *Circular[T, P]) Producer() Producer[T, P] {
+	return q
+}
+
+// Push adds an element to the queue.
+//
+// If the queue is full, Push follows the queue's overflow policy: it either
+// blocks until space is available, or evicts the oldest element in the queue.
+func (q *Circular[T, P]) Push(p P) error {
+	_, err := q.PushEvict(p)
+	return err
+}
+
+// PushEvict adds an element to the queue, and returns the element that was
+// evicted from the head of the queue to make room for it, if any.
+//
+// Elements are only evicted when the queue uses the DropOldest overflow policy,
+// in which case the evicted element is removed from the queue and belongs to the
+// caller. Under any other policy PushEvict behaves like Push and the evicted
+// element is always nil.
+func (q *Circular[T, P]) PushEvict(p P) (evicted P, err error) {
+	return q.pushEvictContext(context.Background(), p)
+}
+
+// PushContext is like Push, but stops waiting for space and returns the
+// context's error once ctx is done. The queue is left unchanged in that case.
+func (q *Circular[T, P]) PushContext(ctx context.Context, p P) error {
+	_, err := q.pushEvictContext(ctx, p)
+	return err
+}
+
+// PushTimeout is like Push, but gives up waiting for space and returns
+// ErrTimeout after d. The queue is left unchanged in that case.
+func (q *Circular[T, P]) PushTimeout(p P, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return timeoutErr(q.PushContext(ctx, p))
+}
+
+// pushEvictContext is an internal function used to implement PushEvict and
+// PushContext.
+func (q *Circular[T, P]) pushEvictContext(ctx context.Context, p P) (evicted P, err error) {
+	if p == nil && q.rejectNil {
+		return nil, ErrNilElement
+	}
+	blocked := false
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err = q.err
+		q.lock.Unlock()
+		return nil, err
+	}
+	if q.isFull() {
+		switch {
+		case q.overflow == DropOldest:
+			evicted = q.evict()
+		case q.overflow == Grow && q.grow():
+		default:
+			if !blocked {
+				blocked = true
+				atomic.AddUint64(&q.stats.pushBlocks, 1)
+			}
+			if err = q.waitNotFullContext(ctx); err != nil {
+				q.lock.Unlock()
+				return nil, err
+			}
+			goto LOOP
+		}
+	}
+
+	q.push(p)
+	onPush := q.onPush
 	q.lock.Unlock()
+	report(onPush, p)
 	return
 }
 
-// Drain removes all elements from the queue.
-// and returns them in a slice.
+// PushFront adds an element to the head of the queue, so that the next Pop
+// returns it ahead of any elements added with Push.
 //
-// This function should only be called after the queue is closed.
-func (q *Circular[T, P]) Drain() (values []P) {
+// If the queue is full, PushFront follows the queue's overflow policy just like
+// Push does. Under the DropOldest policy, the element it overwrites is the one
+// at the other end of the queue, the one PopBack would return, since the
+// element at the head is the one being pushed in front of.
+func (q *Circular[T, P]) PushFront(p P) error {
+	if p == nil && q.rejectNil {
+		return ErrNilElement
+	}
+	blocked := false
 	q.lock.Lock()
-	if q.isEmpty() {
+LOOP:
+	if q.isClosed() {
+		err := q.err
 		q.lock.Unlock()
-		return nil
+		return err
 	}
-	if size := int(q.head) - int(q.tail); size > 0 {
-		values = make([]P, 0, size)
-	} else {
-		values = make([]P, 0, -1*size)
+	if q.isFull() {
+		switch {
+		case q.overflow == DropOldest:
+			q.evictBack()
+		case q.overflow == Grow && q.grow():
+		default:
+			if !blocked {
+				blocked = true
+				atomic.AddUint64(&q.stats.pushBlocks, 1)
+			}
+			if err := q.waitNotFull(); err != nil {
+				q.lock.Unlock()
+				return err
+			}
+			goto LOOP
+		}
 	}
-	for i := 0; i < cap(values); i++ {
-		values = append(values, q.nodes[q.head])
-		q.head = (q.head + 1) % q.maxSize
+
+	q.pushFront(p)
+	onPush := q.onPush
+	q.lock.Unlock()
+	report(onPush, p)
+	return nil
+}
+
+// PopBack removes the element at the tail of the queue, the one most recently
+// added with Push, blocking until one is available. Together with PushFront,
+// this lets the queue be used as a double-ended queue. Elements at the tail
+// that match the skip predicate are discarded first, like Pop does at the head.
+func (q *Circular[T, P]) PopBack() (p P, err error) {
+	blocked := false
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err = q.err
+		q.lock.Unlock()
+		return nil, err
 	}
+	q.discardBack()
+	if q.isEmpty() {
+		if !blocked {
+			blocked = true
+			atomic.AddUint64(&q.stats.popBlocks, 1)
+		}
+		if err := q.waitNotEmpty(); err != nil {
+			q.lock.Unlock()
+			return nil, err
+		}
+		goto LOOP
+	}
+
+	p = q.evictBack()
+	atomic.AddUint64(&q.stats.pops, 1)
+	q.updateSize()
+	q.notFull.Signal()
+	onPop := q.onPop
 	q.lock.Unlock()
-	return values
+	report(onPop, p)
+	return
+}
+
+// evictBack is an internal function used to remove the element at the tail of
+// the queue, which must not be empty.
+func (q *Circular[T, P]) evictBack() (p P) {
+	q.tail = (q.tail + q.maxSize - 1) % q.maxSize
+	p = q.nodes[q.tail]
+	q.nodes[q.tail] = nil
+	return
+}
+
+// discardBack is an internal function used to remove the elements at the tail
+// of the queue that match the skip predicate.
+func (q *Circular[T, P]) discardBack() {
+	if q.skip == nil {
+		return
+	}
+	freed := 0
+	for !q.isEmpty() && q.skip(q.nodes[(q.tail+q.maxSize-1)%q.maxSize]) {
+		q.evictBack()
+		atomic.AddUint64(&q.stats.skipped, 1)
+		freed++
+	}
+	if freed > 0 {
+		q.updateSize()
+		q.notFull.Broadcast()
+	}
+}
+
+// pushFront is an internal function used to add an element to the head of the
+// queue, which must not be full.
+func (q *Circular[T, P]) pushFront(p P) {
+	q.allocate()
+	q.head = (q.head + q.maxSize - 1) % q.maxSize
+	q.nodes[q.head] = p
+	atomic.AddUint64(&q.stats.pushes, 1)
+	q.updateSize()
+	q.signalNotEmpty()
+}
+
+// grow is an internal function used to double the size of the queue's backing
+// array, without exceeding its maximum capacity. It returns false if the queue
+// is already at its maximum capacity.
+func (q *Circular[T, P]) grow() bool {
+	current := q.capacity() + 1
+	size := current * 2
+	if q.maxSlots > 0 && size > q.maxSlots {
+		size = q.maxSlots
+	}
+	if size <= current {
+		return false
+	}
+	if size < q.maxSize {
+		// requeue has already grown the backing array past the new size.
+		q.capSlots = size
+		return true
+	}
+	if size > q.maxSize {
+		q.resize(size)
+	}
+	q.capSlots = 0
+	return true
+}
+
+// resize is an internal function used to replace the queue's backing array with
+// one of the given size, which must be able to hold every element in the queue.
+// The elements are copied in FIFO order to the start of the new array.
+func (q *Circular[T, P]) resize(size uint64) {
+	nodes := make([]P, size)
+	first, second := q.slices()
+	n := copy(nodes, first)
+	n += copy(nodes[n:], second)
+	q.nodes = nodes
+	q.maxSize = size
+	q.head = 0
+	q.tail = uint64(n)
+}
+
+// Compact shrinks the queue's backing array after it has grown, if the queue is
+// at most a quarter full. The new array is the smallest one that fits the
+// elements currently in the queue, but never smaller than the one the queue was
+// created with. Compact is a no-op if the queue is fuller than that or has
+// never grown.
+//
+// This is meant to be called during idle periods to reclaim the memory used by
+// a queue that grew with the Grow overflow policy during a spike.
+func (q *Circular[T, P]) Compact() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	n := uint64(q.length())
+	if q.capSlots > 0 || n > (q.maxSize-1)/4 {
+		return
+	}
+	size := q.slotsFor(n)
+	if size < q.minSlots {
+		size = q.minSlots
+	}
+	if size < q.maxSize {
+		q.resize(size)
+	}
+}
+
+// Resize changes the number of elements the queue can hold, copying the
+// elements in it to a new backing array in FIFO order. Like the size passed to
+// NewCircular, the new capacity may be rounded up.
+//
+// Growing the queue always succeeds and wakes up blocked producers. Shrinking
+// it below its current length fails with CapacityError and leaves the queue
+// unchanged, rather than waiting for elements to be popped. The new capacity
+// also becomes the minimum capacity for Compact, and raises the maximum
+// capacity of a queue that uses the Grow overflow policy if needed.
+func (q *Circular[T, P]) Resize(newCap int) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.isClosed() {
+		return q.err
+	}
+	if newCap < q.length() {
+		return CapacityError
+	}
+	size := q.slotsFor(uint64(newCap))
+	q.minSlots = size
+	if q.maxSlots > 0 && q.maxSlots < size {
+		q.maxSlots = size
+	}
+	q.capSlots = 0
+	if size != q.maxSize {
+		grew := size > q.maxSize
+		q.resize(size)
+		if grew {
+			q.notFull.Broadcast()
+		}
+	}
+	return nil
+}
+
+// evict is an internal function used to remove the element at the head
+// of the queue to make room for a new element.
+func (q *Circular[T, P]) evict() (p P) {
+	p = q.nodes[q.head]
+	q.nodes[q.head] = nil
+	q.head = (q.head + 1) % q.maxSize
+	return
+}
+
+// allocate is an internal function used to allocate the backing array of a
+// queue created with lazy allocation, before the first element is added to it.
+func (q *Circular[T, P]) allocate() {
+	if q.nodes == nil {
+		q.nodes = make([]P, q.maxSize)
+	}
+}
+
+// push is an internal function used to add an element to the tail of the queue,
+// which must not be full.
+func (q *Circular[T, P]) push(p P) {
+	q.allocate()
+	q.nodes[q.tail] = p
+	q.tail = (q.tail + 1) % q.maxSize
+	atomic.AddUint64(&q.stats.pushes, 1)
+	q.updateSize()
+	q.signalNotEmpty()
+}
+
+// PushN adds all the given elements to the queue, in order, taking the lock
+// once for as many elements as fit at a time rather than once per element.
+// If the queue is full, PushN follows the queue's overflow policy just like
+// Push does, blocking until space is available under the Block policy.
+//
+// It returns the number of elements that were added, which is less than
+// len(vals) only if the queue is closed before all of them were added, in
+// which case the same error as Push is returned along with it.
+func (q *Circular[T, P]) PushN(vals []P) (pushed int, err error) {
+	if q.rejectNil {
+		for _, p := range vals {
+			if p == nil {
+				return 0, ErrNilElement
+			}
+		}
+	}
+	blocked := false
+	q.lock.Lock()
+PUSH:
+	for pushed < len(vals) {
+		if q.isClosed() {
+			err = q.err
+			break
+		}
+		if q.isFull() {
+			switch {
+			case q.overflow == DropOldest:
+				q.evict()
+			case q.overflow == Grow && q.grow():
+			default:
+				if !blocked {
+					blocked = true
+					atomic.AddUint64(&q.stats.pushBlocks, 1)
+				}
+				if err = q.waitNotFull(); err != nil {
+					break PUSH
+				}
+				continue
+			}
+		}
+		q.push(vals[pushed])
+		pushed++
+	}
+	onPush := q.onPush
+	q.lock.Unlock()
+	report(onPush, vals[:pushed]...)
+	return
+}
+
+// PushSome adds as many of the given elements to the queue as currently fit,
+// in order, without blocking. It returns the number of elements that were
+// accepted, so the caller can retry or shed the rest.
+//
+// If the queue is closed, PushSome returns Closed along with the number of
+// elements that were accepted before the queue was closed. If the queue
+// rejects nil elements, PushSome stops at the first nil element and returns
+// ErrNilElement along with the number of elements accepted before it.
+func (q *Circular[T, P]) PushSome(items []P) (accepted int, err error) {
+	q.lock.Lock()
+	if q.isClosed() {
+		err = q.err
+		q.lock.Unlock()
+		return 0, err
+	}
+	for accepted < len(items) && (!q.isFull() || (q.overflow == Grow && q.grow())) {
+		if items[accepted] == nil && q.rejectNil {
+			err = ErrNilElement
+			break
+		}
+		q.push(items[accepted])
+		accepted++
+	}
+	onPush := q.onPush
+	q.lock.Unlock()
+	report(onPush, items[:accepted]...)
+	return
+}
+
+// TryPush adds an element to the queue if there is space for it, without
+// blocking, and returns false without changing the queue if it is full.
+// Like PushSome, it grows the queue under the Grow overflow policy but never
+// evicts elements.
+func (q *Circular[T, P]) TryPush(p P) (bool, error) {
+	if p == nil && q.rejectNil {
+		return false, ErrNilElement
+	}
+	q.lock.Lock()
+	if q.isClosed() {
+		err := q.err
+		q.lock.Unlock()
+		return false, err
+	}
+	if q.isFull() && !(q.overflow == Grow && q.grow()) {
+		q.lock.Unlock()
+		return false, nil
+	}
+	q.push(p)
+	onPush := q.onPush
+	q.lock.Unlock()
+	report(onPush, p)
+	return true, nil
+}
+
+// TryPop removes an element from the queue if one is available, without
+// blocking, and returns false without changing the queue if it is empty.
+func (q *Circular[T, P]) TryPop() (P, bool, error) {
+	q.lock.Lock()
+	if q.isClosed() {
+		err := q.err
+		q.lock.Unlock()
+		return nil, false, err
+	}
+	p, ok := q.pop()
+	onPop := q.onPop
+	q.lock.Unlock()
+	if ok {
+		report(onPop, p)
+	}
+	return p, ok, nil
+}
+
+// PopIf removes the element at the head of the queue only if pred returns
+// true for it, without blocking. It returns false and leaves the queue
+// unchanged if the queue is empty or pred rejects the head.
+//
+// The predicate is called while the queue's lock is held, so the element it
+// sees is guaranteed to be the one that is removed, but it must not call back
+// into the queue.
+func (q *Circular[T, P]) PopIf(pred func(P) bool) (P, bool, error) {
+	q.lock.Lock()
+	if q.isClosed() {
+		err := q.err
+		q.lock.Unlock()
+		return nil, false, err
+	}
+	q.discard()
+	if q.isEmpty() || !pred(q.nodes[q.head]) {
+		q.lock.Unlock()
+		return nil, false, nil
+	}
+	p, _ := q.pop()
+	onPop := q.onPop
+	q.lock.Unlock()
+	report(onPop, p)
+	return p, true, nil
+}
+
+// Pop removes an element from the queue.
+func (q *Circular[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopOk is like Pop, but reports closure with a false result instead of an
+// error, mirroring a channel receive, so that a consumer can be written as
+//
+//	for p, ok := q.PopOk(); ok; p, ok = q.PopOk() {
+//		...
+//	}
+//
+// Like a channel receive, and unlike Pop, it keeps returning the elements
+// left in the queue after it is closed, and only returns false once the
+// queue is both closed and empty.
+//
+// Since it can't report ErrTooManyWaiters, PopOk is not held back by the
+// limit set with WithMaxWaiters and always waits, although it still counts
+// towards the limit for the other pop methods.
+func (q *Circular[T, P]) PopOk() (P, bool) {
+	blocked := false
+	q.lock.Lock()
+LOOP:
+	p, ok := q.pop()
+	if !ok {
+		if q.isClosed() {
+			q.lock.Unlock()
+			return nil, false
+		}
+		if !blocked {
+			blocked = true
+			atomic.AddUint64(&q.stats.popBlocks, 1)
+		}
+		_ = q.waitNotEmptyUnlimited(context.Background())
+		goto LOOP
+	}
+	onPop := q.onPop
+	q.lock.Unlock()
+	report(onPop, p)
+	return p, true
+}
+
+// PopContext is like Pop, but stops waiting for an element and returns the
+// context's error once ctx is done. The queue is left unchanged in that case.
+func (q *Circular[T, P]) PopContext(ctx context.Context) (p P, err error) {
+	blocked := false
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err = q.err
+		q.lock.Unlock()
+		return nil, err
+	}
+	p, ok := q.pop()
+	if !ok {
+		if !blocked {
+			blocked = true
+			atomic.AddUint64(&q.stats.popBlocks, 1)
+		}
+		if err = q.waitNotEmptyContext(ctx); err != nil {
+			q.lock.Unlock()
+			return nil, err
+		}
+		goto LOOP
+	}
+	onPop := q.onPop
+	q.lock.Unlock()
+	report(onPop, p)
+	return
+}
+
+// PopTimeout is like Pop, but gives up waiting for an element and returns
+// ErrTimeout after d. An element pushed after PopTimeout gives up stays in
+// the queue for the next consumer.
+func (q *Circular[T, P]) PopTimeout(d time.Duration) (P, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	p, err := q.PopContext(ctx)
+	return p, timeoutErr(err)
+}
+
+// timeoutErr is an internal function used to turn the error returned when
+// the context created by PushTimeout or PopTimeout expires into ErrTimeout.
+func timeoutErr(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
+
+// PopTimed removes an element from the queue and also returns how long the
+// call spent blocked waiting for an element to become available.
+//
+// The wait duration is zero if an element was available immediately, in which
+// case the clock is never read.
+func (q *Circular[T, P]) PopTimed() (p P, waited time.Duration, err error) {
+	var start time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err = q.err
+		q.lock.Unlock()
+		if !start.IsZero() {
+			waited = time.Since(start)
+		}
+		return nil, waited, err
+	}
+	p, ok := q.pop()
+	if !ok {
+		if start.IsZero() {
+			start = time.Now()
+			atomic.AddUint64(&q.stats.popBlocks, 1)
+		}
+		if err := q.waitNotEmpty(); err != nil {
+			q.lock.Unlock()
+			return nil, time.Since(start), err
+		}
+		goto LOOP
+	}
+	onPop := q.onPop
+	q.lock.Unlock()
+	report(onPop, p)
+	if !start.IsZero() {
+		waited = time.Since(start)
+	}
+	return
+}
+
+// PopN removes up to max elements from the queue and returns them in FIFO
+// order, taking the lock once rather than once per element. It blocks until at
+// least one element is available, then takes whatever else is already queued
+// without blocking again.
+func (q *Circular[T, P]) PopN(max int) ([]P, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	blocked := false
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err := q.err
+		q.lock.Unlock()
+		return nil, err
+	}
+	q.discard()
+	if q.isEmpty() {
+		if !blocked {
+			blocked = true
+			atomic.AddUint64(&q.stats.popBlocks, 1)
+		}
+		if err := q.waitNotEmpty(); err != nil {
+			q.lock.Unlock()
+			return nil, err
+		}
+		goto LOOP
+	}
+	n := q.length()
+	if n > max {
+		n = max
+	}
+	values := make([]P, 0, n)
+	for len(values) < max {
+		p, ok := q.pop()
+		if !ok {
+			break
+		}
+		values = append(values, p)
+	}
+	onPop := q.onPop
+	q.lock.Unlock()
+	report(onPop, values...)
+	return values, nil
+}
+
+// PopBatchInto removes up to len(dst) elements from the queue and writes them
+// to dst in FIFO order, returning how many were written. It blocks until at
+// least one element is available, then takes whatever else is already queued
+// without blocking again.
+//
+// Since dst is provided by the caller, it can be reused across calls to batch
+// elements without allocating.
+func (q *Circular[T, P]) PopBatchInto(dst []P) (n int, err error) {
+	if len(dst) == 0 {
+		return 0, nil
+	}
+	blocked := false
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err = q.err
+		q.lock.Unlock()
+		return 0, err
+	}
+	for n < len(dst) {
+		p, ok := q.pop()
+		if !ok {
+			break
+		}
+		dst[n] = p
+		n++
+	}
+	if n == 0 {
+		if !blocked {
+			blocked = true
+			atomic.AddUint64(&q.stats.popBlocks, 1)
+		}
+		if err := q.waitNotEmpty(); err != nil {
+			q.lock.Unlock()
+			return 0, err
+		}
+		goto LOOP
+	}
+	onPop := q.onPop
+	q.lock.Unlock()
+	report(onPop, dst[:n]...)
+	return
+}
+
+// PopBatchBytes removes elements from the queue until the combined weight of
+// the next element and the ones already removed would exceed maxBytes, or the
+// queue is empty, and returns them in FIFO order. The weight of each element is
+// computed with weigh.
+//
+// It blocks until at least one element is available, and always returns at
+// least one element, even if it alone exceeds maxBytes.
+func (q *Circular[T, P]) PopBatchBytes(maxBytes int, weigh func(P) int) ([]P, error) {
+	blocked := false
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err := q.err
+		q.lock.Unlock()
+		return nil, err
+	}
+	p, ok := q.pop()
+	if !ok {
+		if !blocked {
+			blocked = true
+			atomic.AddUint64(&q.stats.popBlocks, 1)
+		}
+		if err := q.waitNotEmpty(); err != nil {
+			q.lock.Unlock()
+			return nil, err
+		}
+		goto LOOP
+	}
+	values := []P{p}
+	total := weigh(p)
+	for {
+		q.discard()
+		if q.isEmpty() {
+			break
+		}
+		w := weigh(q.nodes[q.head])
+		if total+w > maxBytes {
+			break
+		}
+		p, _ = q.pop()
+		values = append(values, p)
+		total += w
+	}
+	onPop := q.onPop
+	q.lock.Unlock()
+	report(onPop, values...)
+	return values, nil
+}
+
+// Peek returns the element at the head of the queue without removing it,
+// blocking until one is available. Elements that match the skip predicate are
+// discarded first, so the next Pop returns the same element unless another
+// consumer takes it in between.
+func (q *Circular[T, P]) Peek() (P, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+LOOP:
+	if q.isClosed() {
+		return nil, q.err
+	}
+	q.discard()
+	if q.isEmpty() {
+		if err := q.waitNotEmpty(); err != nil {
+			return nil, err
+		}
+		goto LOOP
+	}
+	// Peek leaves the element in the queue, so a wakeup it consumed has
+	// to be passed on to the next blocked consumer.
+	q.signalNotEmpty()
+	return q.nodes[q.head], nil
+}
+
+// TryPeek is like Peek, but returns false instead of blocking if the queue
+// is empty.
+func (q *Circular[T, P]) TryPeek() (P, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.isClosed() {
+		return nil, false, q.err
+	}
+	q.discard()
+	if q.isEmpty() {
+		return nil, false, nil
+	}
+	return q.nodes[q.head], true, nil
+}
+
+// PeekBatch returns a copy of up to n elements from the head of the queue,
+// in FIFO order, without removing them. It blocks until at least one element
+// is available. Elements that match the skip predicate are left out, since
+// they will never be returned by Pop.
+func (q *Circular[T, P]) PeekBatch(n int) ([]P, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err := q.err
+		q.lock.Unlock()
+		return nil, err
+	}
+	values := q.peek(n)
+	if len(values) == 0 {
+		if err := q.waitNotEmpty(); err != nil {
+			q.lock.Unlock()
+			return nil, err
+		}
+		goto LOOP
+	}
+	// Like Peek, nothing was removed, so wake up the next blocked consumer.
+	q.signalNotEmpty()
+	q.lock.Unlock()
+	return values, nil
+}
+
+// peek is an internal function used to copy up to n elements from the head
+// of the queue, leaving out any elements that match the skip predicate.
+func (q *Circular[T, P]) peek(n int) (values []P) {
+	for i := q.head; i != q.tail && len(values) < n; i = (i + 1) % q.maxSize {
+		if q.skip != nil && q.skip(q.nodes[i]) {
+			continue
+		}
+		values = append(values, q.nodes[i])
+	}
+	return
+}
+
+// pop is an internal function used to remove the element at the head of the queue,
+// discarding any elements that match the skip predicate along the way. It returns
+// false if the queue is empty or every available element was skipped.
+func (q *Circular[T, P]) pop() (p P, ok bool) {
+	q.discard()
+	if q.isEmpty() {
+		return nil, false
+	}
+	p = q.nodes[q.head]
+	q.nodes[q.head] = nil
+	q.head = (q.head + 1) % q.maxSize
+	atomic.AddUint64(&q.stats.pops, 1)
+	q.updateSize()
+	q.notFull.Signal()
+	return p, true
+}
+
+// discard is an internal function used to remove the elements at the head
+// of the queue that match the skip predicate.
+func (q *Circular[T, P]) discard() {
+	if q.skip == nil {
+		return
+	}
+	freed := 0
+	for !q.isEmpty() && q.skip(q.nodes[q.head]) {
+		q.nodes[q.head] = nil
+		q.head = (q.head + 1) % q.maxSize
+		atomic.AddUint64(&q.stats.skipped, 1)
+		freed++
+	}
+	if freed > 0 {
+		q.updateSize()
+		q.notFull.Broadcast()
+	}
+}
+
+// ReplaceHead atomically replaces the element at the head of the queue with p
+// and returns the element it replaced, blocking until the queue has a head.
+//
+// Only the value at the head is swapped: the length of the queue and the
+// order of the other elements are unchanged, so the next Pop returns p.
+func (q *Circular[T, P]) ReplaceHead(p P) (old P, err error) {
+	if p == nil && q.rejectNil {
+		return nil, ErrNilElement
+	}
+	q.lock.Lock()
+LOOP:
+	if q.isClosed() {
+		err = q.err
+		q.lock.Unlock()
+		return nil, err
+	}
+	q.discard()
+	if q.isEmpty() {
+		if err := q.waitNotEmpty(); err != nil {
+			q.lock.Unlock()
+			return nil, err
+		}
+		goto LOOP
+	}
+	old = q.nodes[q.head]
+	q.nodes[q.head] = p
+	// The length is unchanged, so a wakeup consumed while waiting for the
+	// head still belongs to the next blocked consumer.
+	q.signalNotEmpty()
+	q.lock.Unlock()
+	return
+}
+
+// At returns the element at index i without removing it, where index 0 is the
+// head of the queue, the element the next Pop would return. It returns
+// OutOfRangeError if i is not less than Length.
+//
+// Indices are relative to the head, so they shift down by one every time an
+// element is popped. Looking up an index is O(1).
+func (q *Circular[T, P]) At(i int) (P, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.isClosed() {
+		return nil, q.err
+	}
+	if i < 0 || i >= q.length() {
+		return nil, OutOfRangeError
+	}
+	return q.nodes[(q.head+uint64(i))%q.maxSize], nil
+}
+
+// SetAt replaces the element at index i with p, without changing the order of
+// the queue. Indices work the same way as they do for At.
+func (q *Circular[T, P]) SetAt(i int, p P) error {
+	if p == nil && q.rejectNil {
+		return ErrNilElement
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.isClosed() {
+		return q.err
+	}
+	if i < 0 || i >= q.length() {
+		return OutOfRangeError
+	}
+	q.nodes[(q.head+uint64(i))%q.maxSize] = p
+	return nil
+}
+
+// RemoveFunc removes every element for which pred returns true from the queue
+// and returns them, in FIFO order, so that they can be recycled. The remaining
+// elements keep their relative order.
+//
+// RemoveFunc scans the whole queue while holding its lock, so it is O(n) and
+// meant for infrequent operations such as cancelling the queued tasks of a
+// disconnected client. pred must not call any method on the queue.
+func (q *Circular[T, P]) RemoveFunc(pred func(P) bool) (removed []P, err error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.isClosed() {
+		return nil, q.err
+	}
+	n := uint64(q.length())
+	kept := uint64(0)
+	for i := uint64(0); i < n; i++ {
+		p := q.nodes[(q.head+i)%q.maxSize]
+		if pred(p) {
+			removed = append(removed, p)
+			continue
+		}
+		q.nodes[(q.head+kept)%q.maxSize] = p
+		kept++
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	for i := kept; i < n; i++ {
+		q.nodes[(q.head+i)%q.maxSize] = nil
+	}
+	q.tail = (q.head + kept) % q.maxSize
+	q.updateSize()
+	q.notFull.Broadcast()
+	return removed, nil
+}
+
+// SetSkipPredicate sets a predicate that is used to discard elements as they are
+// popped. Elements for which skip returns true are removed from the queue and
+// their slots cleared, but are never returned to the caller, and Pop keeps
+// blocking until an element that should not be skipped is available.
+//
+// This is useful for elements that can be cancelled after they are pushed.
+// Drain and the other methods that remove every element at once are not affected.
+// The predicate is called with the queue's lock held, so it must not call any
+// method on the queue. Passing nil removes the predicate.
+func (q *Circular[T, P]) SetSkipPredicate(skip func(P) bool) {
+	q.lock.Lock()
+	q.skip = skip
+	q.lock.Unlock()
+}
+
+// Skipped returns the number of elements that have been discarded
+// because they matched the skip predicate.
+func (q *Circular[T, P]) Skipped() uint64 {
+	return atomic.LoadUint64(&q.stats.skipped)
+}
+
+// OnPush sets a hook that is called with every element added to the queue by
+// one of the Push methods, once the push has succeeded. OnPop does the same
+// for every element removed by one of the Pop methods. Failed calls, elements
+// evicted by the DropOldest policy, and elements removed by Drain, RemoveFunc
+// and the other methods that remove elements in bulk are not reported.
+//
+// The hooks are called after the queue's lock is released, so they can call
+// other methods on the queue, but they run on the goroutine that pushed or
+// popped and delay its return. Passing nil removes the hook.
+func (q *Circular[T, P]) OnPush(hook func(P)) {
+	q.lock.Lock()
+	q.onPush = hook
+	q.lock.Unlock()
+}
+
+// OnPop sets a hook that is called with every element removed from the queue
+// by one of the Pop methods, as described for OnPush.
+func (q *Circular[T, P]) OnPop(hook func(P)) {
+	q.lock.Lock()
+	q.onPop = hook
+	q.lock.Unlock()
+}
+
+// report is an internal function used to call a push or pop hook, which was
+// read while holding the queue's lock, with the given elements.
+func report[P any](hook func(P), values ...P) {
+	if hook != nil {
+		for _, p := range values {
+			hook(p)
+		}
+	}
+}
+
+// Drain removes all elements from the queue and returns them in FIFO order.
+//
+// The queue is emptied atomically, so no concurrent Pop can return an element
+// that Drain also returns, and blocked producers are woken up. Drain can be
+// called after the queue is closed to recover the elements left in it.
+func (q *Circular[T, P]) Drain() []P {
+	q.lock.Lock()
+	values := q.drain()
+	q.notFull.Broadcast()
+	q.lock.Unlock()
+	return values
+}
+
+// Clear removes all elements from the queue without returning them, so unlike
+// Drain it never allocates. Blocked producers are woken up, since the whole
+// queue is free again. Like Drain, it can be called after the queue is closed.
+func (q *Circular[T, P]) Clear() {
+	q.lock.Lock()
+	n := q.length()
+	for ; q.head != q.tail; q.head = (q.head + 1) % q.maxSize {
+		q.nodes[q.head] = nil
+	}
+	q.head = 0
+	q.tail = 0
+	atomic.AddUint64(&q.stats.pops, uint64(n))
+	q.updateSize()
+	q.notFull.Broadcast()
+	q.lock.Unlock()
+}
+
+// DrainN removes up to max elements from the queue without blocking and
+// returns them in FIFO order. It may return fewer than max elements, even none,
+// and sets more to true if elements are still left in the queue afterward.
+//
+// This allows flushing the queue in bounded chunks while it is still in use.
+// It returns the same error as Pop if the queue is closed.
+func (q *Circular[T, P]) DrainN(max int) (items []P, more bool, err error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.isClosed() {
+		return nil, false, q.err
+	}
+	if max > 0 {
+		n := q.length()
+		if n > max {
+			n = max
+		}
+		items = make([]P, 0, n)
+		for len(items) < max {
+			p, ok := q.pop()
+			if !ok {
+				break
+			}
+			items = append(items, p)
+		}
+	}
+	q.discard()
+	return items, !q.isEmpty(), nil
+}
+
+// DrainTo moves all the elements currently in the queue into dst, in FIFO order,
+// and returns the number of elements that were moved.
+//
+// Elements are moved in batches while holding the locks of both queues. If dst
+// is full, its overflow policy is used to make room for more elements, and if
+// that is not possible DrainTo releases the lock on this queue and blocks until
+// space becomes available in dst. It stops early and returns Closed if either
+// queue is closed, in which case the elements that were not moved remain in
+// this queue.
+func (q *Circular[T, P]) DrainTo(dst *Circular[T, P]) (moved int, err error) {
+	if dst == q {
+		return 0, nil
+	}
+	blocked, woken := false, false
+	lockPair(q, dst)
+	remaining := q.length()
+	for remaining > 0 && !q.isEmpty() {
+		if q.isClosed() {
+			err = q.err
+			break
+		}
+		if dst.isClosed() {
+			err = dst.err
+			break
+		}
+		if dst.isFull() {
+			switch dst.overflow {
+			case DropOldest:
+				dst.evict()
+			case Grow:
+				dst.grow()
+			}
+		}
+		if dst.isFull() {
+			if !blocked {
+				blocked = true
+				atomic.AddUint64(&dst.stats.pushBlocks, 1)
+			}
+			// Waiting on dst must also stop once q is closed, which
+			// q can only signal through a channel.
+			closing := q.closedCh()
+			q.lock.Unlock()
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-closing:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			err = dst.waitNotFullContext(ctx)
+			cancel()
+			if err != nil && err != context.Canceled {
+				dst.lock.Unlock()
+				return
+			}
+			woken = err == nil
+			err = nil
+			dst.lock.Unlock()
+			lockPair(q, dst)
+			continue
+		}
+		woken = false
+		before := moved
+		dst.allocate()
+		for remaining > 0 && !q.isEmpty() && !dst.isFull() {
+			dst.nodes[dst.tail] = q.nodes[q.head]
+			dst.tail = (dst.tail + 1) % dst.maxSize
+			q.nodes[q.head] = nil
+			q.head = (q.head + 1) % q.maxSize
+			moved++
+			remaining--
+		}
+		atomic.AddUint64(&q.stats.pops, uint64(moved-before))
+		atomic.AddUint64(&dst.stats.pushes, uint64(moved-before))
+		q.updateSize()
+		dst.updateSize()
+		q.notFull.Broadcast()
+		dst.signalNotEmpty()
+	}
+	if woken && !dst.isFull() {
+		// The wakeup we consumed was for space that we never used, for
+		// example because another consumer emptied q in the meantime,
+		// so hand it to the next producer blocked on dst.
+		dst.notFull.Signal()
+	}
+	q.lock.Unlock()
+	dst.lock.Unlock()
+	return
+}
+
+// DrainToChan removes all the elements currently in the queue and sends them,
+// in FIFO order, to ch. It returns the number of elements that were sent.
+//
+// The elements are removed from the queue in a single batch before they are
+// sent, so DrainToChan blocks until ch has accepted all of them. It returns
+// Closed if the queue is closed.
+func (q *Circular[T, P]) DrainToChan(ch chan<- P) (int, error) {
+	q.lock.Lock()
+	if q.isClosed() {
+		err := q.err
+		q.lock.Unlock()
+		return 0, err
+	}
+	values := q.drain()
+	q.notFull.Broadcast()
+	q.lock.Unlock()
+	for _, p := range values {
+		ch <- p
+	}
+	return len(values), nil
+}
+
+// ToSlice returns a copy of the elements in the queue, in FIFO order, without
+// removing them. The copy is made while holding the queue's lock and never
+// aliases the queue's backing array, so it is safe to keep and modify.
+func (q *Circular[T, P]) ToSlice() []P {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	first, second := q.slices()
+	values := make([]P, 0, len(first)+len(second))
+	values = append(values, first...)
+	return append(values, second...)
+}
+
+// DrainSlice is like Drain, but returns an empty slice rather than nil if the
+// queue is empty.
+func (q *Circular[T, P]) DrainSlice() []P {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	values := q.drain()
+	if values == nil {
+		values = []P{}
+	}
+	q.notFull.Broadcast()
+	return values
+}
+
+// Equal reports whether other holds the same number of elements as this queue
+// and whether eq returns true for every pair of elements in the same position,
+// comparing them in FIFO order. If eq is nil, elements are compared by pointer.
+//
+// Both queues are locked for the duration of the comparison, so eq must not
+// call any method on either queue.
+func (q *Circular[T, P]) Equal(other *Circular[T, P], eq func(a P, b P) bool) bool {
+	if other == q {
+		return true
+	}
+	lockPair(q, other)
+	defer q.lock.Unlock()
+	defer other.lock.Unlock()
+	n := q.length()
+	if n != other.length() {
+		return false
+	}
+	for i := uint64(0); i < uint64(n); i++ {
+		a := q.nodes[(q.head+i)%q.maxSize]
+		b := other.nodes[(other.head+i)%other.maxSize]
+		if eq == nil {
+			if a != b {
+				return false
+			}
+		} else if !eq(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a new queue with the same capacity, overflow policy, skip
+// predicate and waiter limit as this one, holding the same elements in the
+// same order.
+//
+// The copy is shallow: both queues hold the same pointers, so the pointed-to
+// values are shared, but pushing to or popping from one queue does not affect
+// the other. The new queue is always open, even if this one is closed, and its
+// statistics start from zero. Hooks set with OnPush and OnPop are not copied,
+// since they usually report on this particular queue. Use CloneFunc to copy
+// the elements themselves.
+func (q *Circular[T, P]) Clone() *Circular[T, P] {
+	return q.CloneFunc(nil)
+}
+
+// CloneFunc is like Clone, but stores the result of calling fn on each
+// element in the new queue, which allows making a deep copy of the queue.
+// If fn is nil, the elements are copied as-is.
+//
+// fn is called while the queue's lock is held, so it must not call any method
+// on the queue or it deadlocks.
+func (q *Circular[T, P]) CloneFunc(fn func(P) P) *Circular[T, P] {
+	q.lock.Lock()
+	c := newCircular[T, P](&options{overflow: q.overflow})
+	c.maxSize = q.maxSize
+	c.maxSlots = q.maxSlots
+	c.minSlots = q.minSlots
+	c.capSlots = q.capSlots
+	c.exact = q.exact
+	c.rejectNil = q.rejectNil
+	c.maxWaiters = q.maxWaiters
+	c.skip = q.skip
+	c.nodes = make([]P, q.maxSize)
+	first, second := q.slices()
+	for _, s := range [][]P{first, second} {
+		for _, p := range s {
+			if fn != nil {
+				p = fn(p)
+			}
+			c.nodes[c.tail] = p
+			c.tail++
+		}
+	}
+	q.lock.Unlock()
+	c.updateSize()
+	return c
+}
+
+// drain is an internal function used to remove all elements from the queue
+// and return them in FIFO order.
+func (q *Circular[T, P]) drain() []P {
+	if q.isEmpty() {
+		return nil
+	}
+	values := make([]P, 0, q.length())
+	for !q.isEmpty() {
+		values = append(values, q.nodes[q.head])
+		q.nodes[q.head] = nil
+		q.head = (q.head + 1) % q.maxSize
+	}
+	q.head = 0
+	q.tail = 0
+	atomic.AddUint64(&q.stats.pops, uint64(len(values)))
+	q.updateSize()
+	return values
+}
+
+// lockPair is an internal function used to lock two queues in a consistent
+// order, so that operations spanning two queues can't deadlock each other.
+func lockPair[T any, P Pointer[T]](a *Circular[T, P], b *Circular[T, P]) {
+	if uintptr(unsafe.Pointer(a)) < uintptr(unsafe.Pointer(b)) {
+		a.lock.Lock()
+		b.lock.Lock()
+	} else {
+		b.lock.Lock()
+		a.lock.Lock()
+	}
 }
@@ -3,8 +3,12 @@
 package queue
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -172,6 +176,42 @@ func TestCircular(t *testing.T) {
 		_, err = rb.Pop()
 		assert.ErrorIs(t, Closed, err)
 	})
+	t.Run("close wakes blocked push", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		require.NoError(t, rb.Push(testPacket()))
+		done := make(chan error, 1)
+		go func() {
+			done <- rb.Push(testPacket())
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaitingPushers() == 1
+		}, time.Second, time.Millisecond)
+		rb.Close()
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, Closed)
+		case <-time.After(time.Second):
+			t.Fatal("blocked Push did not return after Close")
+		}
+	})
+	t.Run("close wakes blocked pop", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		done := make(chan error, 1)
+		go func() {
+			_, err := rb.Pop()
+			done <- err
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaitingPoppers() == 1
+		}, time.Second, time.Millisecond)
+		rb.Close()
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, Closed)
+		case <-time.After(time.Second):
+			t.Fatal("blocked Pop did not return after Close")
+		}
+	})
 	t.Run("pop empty", func(t *testing.T) {
 		done := make(chan struct{}, 1)
 		rb := NewCircular[P, *P](1)
@@ -312,3 +352,2944 @@ func TestCircular(t *testing.T) {
 		assert.Equal(t, 2, rb.Length())
 	})
 }
+
+func TestCircularProducerConsumer(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](4)
+	producer := rb.Producer()
+	consumer := rb.Consumer()
+	assertProducerConsumer(t, producer, consumer)
+
+	p := &P{Int: 1}
+	err := producer.Push(p)
+	require.NoError(t, err)
+	assert.Equal(t, 1, producer.Length())
+	assert.Equal(t, 1, consumer.Length())
+
+	actual, err := consumer.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, p, actual)
+	assert.Equal(t, 0, rb.Length())
+
+	producer.Close()
+	_, err = consumer.Pop()
+	assert.ErrorIs(t, err, Closed)
+}
+
+func TestCircularWithSlices(t *testing.T) {
+	collect := func(rb *Circular[P, *P]) (first []int, second []int) {
+		rb.WithSlices(func(a []*P, b []*P) {
+			for _, p := range a {
+				first = append(first, p.Int)
+			}
+			for _, p := range b {
+				second = append(second, p.Int)
+			}
+		})
+		return
+	}
+
+	rb := NewCircular[P, *P](3)
+	first, second := collect(rb)
+	assert.Empty(t, first)
+	assert.Empty(t, second)
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	first, second = collect(rb)
+	assert.Equal(t, []int{1, 2, 3}, first)
+	assert.Empty(t, second)
+
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Push(&P{Int: 4}))
+	require.NoError(t, rb.Push(&P{Int: 5}))
+
+	first, second = collect(rb)
+	assert.Equal(t, []int{3, 4}, first)
+	assert.Equal(t, []int{5}, second)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		rb.WithSlices(func(_ []*P, _ []*P) {})
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestCircularDrainTo(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		src := NewCircular[P, *P](4)
+		dst := NewCircular[P, *P](8)
+		require.NoError(t, dst.Push(&P{Int: 0}))
+		for i := 1; i <= 4; i++ {
+			require.NoError(t, src.Push(&P{Int: i}))
+		}
+
+		moved, err := src.DrainTo(dst)
+		require.NoError(t, err)
+		assert.Equal(t, 4, moved)
+		assert.Equal(t, 0, src.Length())
+		assert.Equal(t, 5, dst.Length())
+
+		for i := 0; i <= 4; i++ {
+			actual, err := dst.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+	})
+	t.Run("destination full, blocking", func(t *testing.T) {
+		src := NewCircular[P, *P](4)
+		dst := NewCircular[P, *P](1)
+		for i := 1; i <= 4; i++ {
+			require.NoError(t, src.Push(&P{Int: i}))
+		}
+
+		doneCh := make(chan int, 1)
+		go func() {
+			moved, err := src.DrainTo(dst)
+			assert.NoError(t, err)
+			doneCh <- moved
+		}()
+
+		for i := 1; i <= 4; i++ {
+			actual, err := dst.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+
+		select {
+		case moved := <-doneCh:
+			assert.Equal(t, 4, moved)
+		case <-time.After(time.Second):
+			t.Fatal("DrainTo did not unblock after destination was drained")
+		}
+		assert.Equal(t, 0, src.Length())
+	})
+	t.Run("unused wakeup is passed on", func(t *testing.T) {
+		src := NewCircular[P, *P](4)
+		dst := NewCircular[P, *P](1)
+		require.NoError(t, src.Push(&P{Int: 1}))
+		require.NoError(t, dst.Push(&P{Int: 0}))
+
+		doneCh := make(chan int, 1)
+		go func() {
+			moved, err := src.DrainTo(dst)
+			assert.NoError(t, err)
+			doneCh <- moved
+		}()
+		require.Eventually(t, func() bool {
+			return dst.WaitingPushers() == 1
+		}, time.Second, time.Millisecond)
+
+		pushCh := make(chan error, 1)
+		go func() {
+			pushCh <- dst.Push(&P{Int: 2})
+		}()
+		require.Eventually(t, func() bool {
+			return dst.WaitingPushers() == 2
+		}, time.Second, time.Millisecond)
+
+		// Another consumer empties src, so DrainTo wakes up with nothing to move.
+		_, err := src.Pop()
+		require.NoError(t, err)
+		_, err = dst.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 0, <-doneCh)
+
+		select {
+		case err := <-pushCh:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("a producer blocked on the destination was not woken up")
+		}
+	})
+	t.Run("source closed while blocked", func(t *testing.T) {
+		src := NewCircular[P, *P](4)
+		dst := NewCircular[P, *P](1)
+		require.NoError(t, src.Push(&P{Int: 1}))
+		require.NoError(t, dst.Push(&P{Int: 0}))
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := src.DrainTo(dst)
+			errCh <- err
+		}()
+		require.Eventually(t, func() bool {
+			return dst.WaitingPushers() == 1
+		}, time.Second, time.Millisecond)
+
+		src.Close()
+		select {
+		case err := <-errCh:
+			assert.ErrorIs(t, err, Closed)
+		case <-time.After(time.Second):
+			t.Fatal("DrainTo did not stop when the source was closed")
+		}
+		assert.Equal(t, 0, dst.WaitingPushers())
+		assert.Equal(t, 1, src.Length())
+		assert.Equal(t, 1, dst.Length())
+	})
+	t.Run("destination closed", func(t *testing.T) {
+		src := NewCircular[P, *P](4)
+		dst := NewCircular[P, *P](1)
+		for i := 1; i <= 4; i++ {
+			require.NoError(t, src.Push(&P{Int: i}))
+		}
+
+		doneCh := make(chan struct{}, 1)
+		go func() {
+			moved, err := src.DrainTo(dst)
+			assert.ErrorIs(t, err, Closed)
+			assert.Less(t, moved, 4)
+			doneCh <- struct{}{}
+		}()
+
+		time.Sleep(time.Millisecond * 10)
+		dst.Close()
+		select {
+		case <-doneCh:
+		case <-time.After(time.Second):
+			t.Fatal("DrainTo did not unblock after destination was closed")
+		}
+
+		assert.Equal(t, 4-dst.Length(), src.Length())
+	})
+	t.Run("same queue", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		require.NoError(t, rb.Push(new(P)))
+		moved, err := rb.DrainTo(rb)
+		require.NoError(t, err)
+		assert.Equal(t, 0, moved)
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("channel", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+
+		ch := make(chan *P, 3)
+		moved, err := rb.DrainToChan(ch)
+		require.NoError(t, err)
+		assert.Equal(t, 3, moved)
+		assert.Equal(t, 0, rb.Length())
+		for i := 1; i <= 3; i++ {
+			assert.Equal(t, i, (<-ch).Int)
+		}
+
+		rb.Close()
+		_, err = rb.DrainToChan(ch)
+		assert.ErrorIs(t, err, Closed)
+	})
+}
+
+func TestCircularLengthApprox(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](8)
+	assert.Equal(t, 0, rb.LengthApprox())
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, rb.Push(new(P)))
+	}
+	assert.Equal(t, 5, rb.LengthApprox())
+
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, 4, rb.LengthApprox())
+
+	rb.Drain()
+	assert.Equal(t, 0, rb.LengthApprox())
+
+	const iterations = 1000
+	doneCh := make(chan struct{})
+	go func() {
+		for i := 0; i < iterations; i++ {
+			_ = rb.Push(new(P))
+			_, _ = rb.Pop()
+		}
+		close(doneCh)
+	}()
+LOOP:
+	for {
+		select {
+		case <-doneCh:
+			break LOOP
+		default:
+			length := rb.LengthApprox()
+			assert.GreaterOrEqual(t, length, 0)
+			assert.LessOrEqual(t, length, 1)
+		}
+	}
+	assert.Equal(t, 0, rb.LengthApprox())
+}
+
+func BenchmarkCircular(b *testing.B) {
+	b.Run("single producer, single consumer", func(b *testing.B) {
+		rb := NewCircular[P, *P](1024)
+		p := new(P)
+		b.ReportAllocs()
+		b.ResetTimer()
+		doneCh := make(chan struct{})
+		go func() {
+			for i := 0; i < b.N; i++ {
+				_, _ = rb.Pop()
+			}
+			close(doneCh)
+		}()
+		for i := 0; i < b.N; i++ {
+			_ = rb.Push(p)
+		}
+		<-doneCh
+	})
+	b.Run("batches of 64 with PopBatchInto", func(b *testing.B) {
+		rb := NewCircular[P, *P](64)
+		p := new(P)
+		batch := make([]*P, 64)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < len(batch); j++ {
+				_ = rb.Push(p)
+			}
+			_, _ = rb.PopBatchInto(batch)
+		}
+	})
+	b.Run("64 single pushes and pops", func(b *testing.B) {
+		rb := NewCircular[P, *P](64)
+		p := new(P)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 64; j++ {
+				_ = rb.Push(p)
+			}
+			for j := 0; j < 64; j++ {
+				_, _ = rb.Pop()
+			}
+		}
+	})
+	b.Run("batches of 64 with PushN and PopN", func(b *testing.B) {
+		rb := NewCircular[P, *P](64)
+		batch := make([]*P, 64)
+		for i := range batch {
+			batch[i] = new(P)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = rb.PushN(batch)
+			_, _ = rb.PopN(64)
+		}
+	})
+	b.Run("batches of 64 with Drain", func(b *testing.B) {
+		rb := NewCircular[P, *P](64)
+		p := new(P)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 64; j++ {
+				_ = rb.Push(p)
+			}
+			_ = rb.Drain()
+		}
+	})
+}
+
+func TestCircularPushSome(t *testing.T) {
+	t.Parallel()
+
+	items := make([]*P, 5)
+	for i := range items {
+		items[i] = &P{Int: i}
+	}
+
+	rb := NewCircular[P, *P](3)
+	accepted, err := rb.PushSome(items)
+	require.NoError(t, err)
+	assert.Equal(t, 3, accepted)
+	assert.Equal(t, 3, rb.Length())
+
+	accepted, err = rb.PushSome(items[accepted:])
+	require.NoError(t, err)
+	assert.Equal(t, 0, accepted)
+
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	accepted, err = rb.PushSome(items[3:])
+	require.NoError(t, err)
+	assert.Equal(t, 1, accepted)
+
+	for _, i := range []int{1, 2, 3} {
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, i, actual.Int)
+	}
+
+	accepted, err = rb.PushSome(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, accepted)
+
+	rb.Close()
+	accepted, err = rb.PushSome(items)
+	assert.ErrorIs(t, err, Closed)
+	assert.Equal(t, 0, accepted)
+}
+
+func TestCircularSkipPredicate(t *testing.T) {
+	t.Parallel()
+
+	cancelled := func(p *P) bool {
+		return p.String == "cancelled"
+	}
+
+	t.Run("skips tombstoned elements", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		rb.SetSkipPredicate(cancelled)
+
+		p1 := &P{Int: 1, String: "cancelled"}
+		p2 := &P{Int: 2}
+		p3 := &P{Int: 3, String: "cancelled"}
+		p4 := &P{Int: 4}
+		for _, p := range []*P{p1, p2, p3, p4} {
+			require.NoError(t, rb.Push(p))
+		}
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p2, actual)
+		assert.Equal(t, 2, rb.Length())
+
+		actual, err = rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p4, actual)
+		assert.Equal(t, 0, rb.Length())
+		assert.Equal(t, uint64(2), rb.Skipped())
+
+		for _, n := range rb.nodes {
+			assert.Nil(t, n)
+		}
+	})
+	t.Run("blocks when every element is skipped", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.SetSkipPredicate(cancelled)
+		require.NoError(t, rb.Push(&P{String: "cancelled"}))
+
+		doneCh := make(chan *P, 1)
+		go func() {
+			actual, err := rb.Pop()
+			assert.NoError(t, err)
+			doneCh <- actual
+		}()
+
+		select {
+		case <-doneCh:
+			t.Fatal("Circular did not block when every element was skipped")
+		case <-time.After(time.Millisecond * 10):
+		}
+
+		p := &P{Int: 1}
+		require.NoError(t, rb.Push(p))
+		select {
+		case actual := <-doneCh:
+			assert.Equal(t, p, actual)
+		case <-time.After(time.Second):
+			t.Fatal("Circular did not unblock on push of a live element")
+		}
+		assert.Equal(t, uint64(1), rb.Skipped())
+	})
+	t.Run("removing the predicate", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.SetSkipPredicate(cancelled)
+		rb.SetSkipPredicate(nil)
+
+		p := &P{String: "cancelled"}
+		require.NoError(t, rb.Push(p))
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p, actual)
+		assert.Equal(t, uint64(0), rb.Skipped())
+	})
+}
+
+func TestCircularPopTimed(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](1)
+	p := new(P)
+	require.NoError(t, rb.Push(p))
+
+	actual, waited, err := rb.PopTimed()
+	require.NoError(t, err)
+	assert.Equal(t, p, actual)
+	assert.Equal(t, time.Duration(0), waited)
+
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		_ = rb.Push(p)
+	}()
+	actual, waited, err = rb.PopTimed()
+	require.NoError(t, err)
+	assert.Equal(t, p, actual)
+	assert.GreaterOrEqual(t, waited, time.Millisecond*10)
+
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		rb.Close()
+	}()
+	_, waited, err = rb.PopTimed()
+	assert.ErrorIs(t, err, Closed)
+	assert.GreaterOrEqual(t, waited, time.Millisecond*10)
+}
+
+func TestCircularStats(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](1)
+	assert.Equal(t, Stats{}, rb.Stats())
+
+	require.NoError(t, rb.Push(new(P)))
+	doneCh := make(chan struct{}, 1)
+	go func() {
+		assert.NoError(t, rb.Push(new(P)))
+		doneCh <- struct{}{}
+	}()
+	time.Sleep(time.Millisecond * 10)
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	<-doneCh
+
+	_, err = rb.Pop()
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		_ = rb.Push(new(P))
+	}()
+	_, err = rb.Pop()
+	require.NoError(t, err)
+
+	assert.Equal(t, Stats{
+		Pushes:     3,
+		Pops:       3,
+		PushBlocks: 1,
+		PopBlocks:  1,
+		PeakLength: 1,
+	}, rb.Stats())
+
+	require.NoError(t, rb.Push(new(P)))
+	rb.ResetStats()
+	assert.Equal(t, Stats{PeakLength: 1}, rb.Stats())
+	assert.Equal(t, 1, rb.Length())
+
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Pops: 1, PeakLength: 1}, rb.Stats())
+
+	rb.ResetStats()
+	assert.Equal(t, Stats{}, rb.Stats())
+}
+
+func TestCircularPeekBatch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		for i := 1; i <= 4; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		for i := 0; i < 3; i++ {
+			_, err := rb.Pop()
+			require.NoError(t, err)
+		}
+		for i := 5; i <= 7; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+
+		values, err := rb.PeekBatch(2)
+		require.NoError(t, err)
+		require.Len(t, values, 2)
+		assert.Equal(t, 4, values[0].Int)
+		assert.Equal(t, 5, values[1].Int)
+
+		values, err = rb.PeekBatch(10)
+		require.NoError(t, err)
+		require.Len(t, values, 4)
+		for i, p := range values {
+			assert.Equal(t, i+4, p.Int)
+		}
+		assert.Equal(t, 4, rb.Length())
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, values[0], actual)
+
+		values, err = rb.PeekBatch(0)
+		require.NoError(t, err)
+		assert.Empty(t, values)
+	})
+	t.Run("blocks on empty", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		doneCh := make(chan []*P, 1)
+		go func() {
+			values, err := rb.PeekBatch(2)
+			assert.NoError(t, err)
+			doneCh <- values
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Circular did not block on empty peek")
+		case <-time.After(time.Millisecond * 10):
+		}
+
+		p := new(P)
+		require.NoError(t, rb.Push(p))
+		select {
+		case values := <-doneCh:
+			assert.Equal(t, []*P{p}, values)
+		case <-time.After(time.Second):
+			t.Fatal("Circular did not unblock peek on push")
+		}
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("wakes a blocked pop", func(t *testing.T) {
+		assertWakesPop(t, func(rb *Circular[P, *P]) {
+			_, err := rb.PeekBatch(2)
+			assert.NoError(t, err)
+		})
+	})
+	t.Run("skips tombstoned elements", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		rb.SetSkipPredicate(func(p *P) bool {
+			return p.String == "cancelled"
+		})
+		require.NoError(t, rb.Push(&P{Int: 1, String: "cancelled"}))
+		require.NoError(t, rb.Push(&P{Int: 2}))
+
+		values, err := rb.PeekBatch(2)
+		require.NoError(t, err)
+		require.Len(t, values, 1)
+		assert.Equal(t, 2, values[0].Int)
+		assert.Equal(t, 2, rb.Length())
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.Close()
+		_, err := rb.PeekBatch(1)
+		assert.ErrorIs(t, err, Closed)
+	})
+}
+
+func TestCircularCloseWithCause(t *testing.T) {
+	t.Parallel()
+
+	cause := errors.New("upstream failed")
+
+	t.Run("plain close", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.Close()
+		err := rb.Push(new(P))
+		assert.Equal(t, Closed, err)
+	})
+	t.Run("cause is propagated", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		doneCh := make(chan error, 1)
+		go func() {
+			_, err := rb.Pop()
+			doneCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.CloseWithCause(cause)
+
+		var err error
+		select {
+		case err = <-doneCh:
+		case <-time.After(time.Second):
+			t.Fatal("Circular did not unblock on close")
+		}
+		assert.ErrorIs(t, err, Closed)
+		assert.ErrorIs(t, err, cause)
+		assert.Equal(t, cause, errors.Unwrap(err))
+		assert.Equal(t, "queue is closed: upstream failed", err.Error())
+
+		err = rb.Push(new(P))
+		assert.ErrorIs(t, err, Closed)
+		assert.ErrorIs(t, err, cause)
+	})
+	t.Run("double close keeps the first cause", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.CloseWithCause(cause)
+		rb.CloseWithCause(errors.New("second cause"))
+		rb.Close()
+		assert.True(t, rb.IsClosed())
+
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, cause)
+
+		rb = NewCircular[P, *P](1)
+		rb.Close()
+		rb.CloseWithCause(cause)
+		_, err = rb.Pop()
+		assert.Equal(t, Closed, err)
+	})
+}
+
+func TestCircularPushEvict(t *testing.T) {
+	t.Parallel()
+
+	t.Run("drop oldest", func(t *testing.T) {
+		rb := NewCircularWithOverflow[P, *P](3, DropOldest)
+		for i := 1; i <= 3; i++ {
+			evicted, err := rb.PushEvict(&P{Int: i})
+			require.NoError(t, err)
+			assert.Nil(t, evicted)
+		}
+
+		evicted, err := rb.PushEvict(&P{Int: 4})
+		require.NoError(t, err)
+		require.NotNil(t, evicted)
+		assert.Equal(t, 1, evicted.Int)
+		assert.Equal(t, 3, rb.Length())
+
+		require.NoError(t, rb.Push(&P{Int: 5}))
+		assert.Equal(t, 3, rb.Length())
+
+		for i := 3; i <= 5; i++ {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+		for _, n := range rb.nodes {
+			assert.Nil(t, n)
+		}
+	})
+	t.Run("block", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		evicted, err := rb.PushEvict(&P{Int: 1})
+		require.NoError(t, err)
+		assert.Nil(t, evicted)
+
+		doneCh := make(chan *P, 1)
+		go func() {
+			evicted, err := rb.PushEvict(&P{Int: 2})
+			assert.NoError(t, err)
+			doneCh <- evicted
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Circular did not block on full write")
+		case <-time.After(time.Millisecond * 10):
+		}
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+		select {
+		case evicted := <-doneCh:
+			assert.Nil(t, evicted)
+		case <-time.After(time.Second):
+			t.Fatal("Circular did not unblock on read from full write")
+		}
+	})
+	t.Run("drain to drop oldest", func(t *testing.T) {
+		src := NewCircular[P, *P](3)
+		dst := NewCircularWithOverflow[P, *P](1, DropOldest)
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, src.Push(&P{Int: i}))
+		}
+		moved, err := src.DrainTo(dst)
+		require.NoError(t, err)
+		assert.Equal(t, 3, moved)
+		actual, err := dst.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 3, actual.Int)
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircularWithOverflow[P, *P](1, DropOldest)
+		rb.Close()
+		_, err := rb.PushEvict(new(P))
+		assert.ErrorIs(t, err, Closed)
+	})
+}
+
+// assertWakesPop checks that wait, a call that blocks until the queue has an
+// element without consuming it, passes its wakeup on to a Pop blocked behind it.
+func assertWakesPop(t *testing.T, wait func(rb *Circular[P, *P])) {
+	t.Helper()
+
+	rb := NewCircular[P, *P](4)
+	waited := make(chan struct{})
+	go func() {
+		wait(rb)
+		close(waited)
+	}()
+	require.Eventually(t, func() bool {
+		return rb.WaitingPoppers() == 1
+	}, time.Second, time.Millisecond)
+
+	popped := make(chan *P, 1)
+	go func() {
+		p, _ := rb.Pop()
+		popped <- p
+	}()
+	require.Eventually(t, func() bool {
+		return rb.WaitingPoppers() == 2
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, rb.Push(&P{Int: 1}))
+	<-waited
+	select {
+	case p := <-popped:
+		assert.NotNil(t, p)
+	case <-time.After(time.Second):
+		t.Fatal("a blocked Pop was not woken up")
+	}
+}
+
+func TestCircularWaiterCount(t *testing.T) {
+	t.Parallel()
+
+	const waiters = 100
+
+	empty := NewCircular[P, *P](1)
+	full := NewCircular[P, *P](1)
+	require.NoError(t, full.Push(new(P)))
+	assert.Equal(t, 0, empty.WaiterCount())
+	assert.Equal(t, 0, full.WaiterCount())
+
+	var wg sync.WaitGroup
+	wg.Add(waiters * 2)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			_, _ = empty.Pop()
+			wg.Done()
+		}()
+		go func() {
+			_ = full.Push(new(P))
+			wg.Done()
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		return empty.WaiterCount() == waiters && full.WaiterCount() == waiters
+	}, time.Second, time.Millisecond)
+
+	empty.Close()
+	full.Close()
+	wg.Wait()
+
+	assert.Equal(t, 0, empty.WaiterCount())
+	assert.Equal(t, 0, full.WaiterCount())
+}
+
+func TestCircularReplaceHead(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		p1 := &P{Int: 1}
+		p2 := &P{Int: 2}
+		urgent := &P{Int: 0}
+		require.NoError(t, rb.Push(p1))
+		require.NoError(t, rb.Push(p2))
+
+		old, err := rb.ReplaceHead(urgent)
+		require.NoError(t, err)
+		assert.Equal(t, p1, old)
+		assert.Equal(t, 2, rb.Length())
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, urgent, actual)
+		actual, err = rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p2, actual)
+	})
+	t.Run("blocks on empty", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		p := &P{Int: 1}
+		doneCh := make(chan *P, 1)
+		go func() {
+			old, err := rb.ReplaceHead(&P{Int: 2})
+			assert.NoError(t, err)
+			doneCh <- old
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Circular did not block on empty replace")
+		case <-time.After(time.Millisecond * 10):
+		}
+		require.NoError(t, rb.Push(p))
+		select {
+		case old := <-doneCh:
+			assert.Equal(t, p, old)
+		case <-time.After(time.Second):
+			t.Fatal("Circular did not unblock replace on push")
+		}
+	})
+	t.Run("wakes a blocked pop", func(t *testing.T) {
+		assertWakesPop(t, func(rb *Circular[P, *P]) {
+			_, err := rb.ReplaceHead(&P{Int: 2})
+			assert.NoError(t, err)
+		})
+	})
+	t.Run("concurrent pop", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		for i := 0; i < 1000; i++ {
+			p1 := &P{Int: 1}
+			p2 := &P{Int: 2}
+			require.NoError(t, rb.Push(p1))
+
+			popCh := make(chan *P, 1)
+			go func() {
+				actual, err := rb.Pop()
+				assert.NoError(t, err)
+				popCh <- actual
+			}()
+			replaceCh := make(chan *P, 1)
+			go func() {
+				old, err := rb.ReplaceHead(p2)
+				assert.NoError(t, err)
+				replaceCh <- old
+			}()
+
+			popped := <-popCh
+			switch popped {
+			case p1:
+				// Pop won the race, so ReplaceHead is waiting for a new head.
+				p3 := &P{Int: 3}
+				require.NoError(t, rb.Push(p3))
+				assert.Equal(t, p3, <-replaceCh)
+				actual, err := rb.Pop()
+				require.NoError(t, err)
+				assert.Equal(t, p2, actual)
+			case p2:
+				assert.Equal(t, p1, <-replaceCh)
+			default:
+				t.Fatalf("Pop returned a torn element: %v", popped)
+			}
+			assert.Equal(t, 0, rb.Length())
+		}
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.Close()
+		_, err := rb.ReplaceHead(new(P))
+		assert.ErrorIs(t, err, Closed)
+	})
+}
+
+func TestCircularWaitUnlock(t *testing.T) {
+	t.Parallel()
+
+	// A compound structure whose state is guarded by its own lock and which
+	// also wants to wake up as soon as the queue receives an element.
+	var mu sync.Mutex
+	ready := false
+
+	rb := NewCircular[P, *P](1)
+	doneCh := make(chan error, 1)
+	go func() {
+		mu.Lock()
+		if ready {
+			mu.Unlock()
+			doneCh <- nil
+			return
+		}
+		// mu is released only once the queue's lock is held, so a Push that
+		// happens after the check above will wake us up.
+		doneCh <- rb.WaitUnlock(mu.Unlock)
+	}()
+	select {
+	case <-doneCh:
+		t.Fatal("Circular did not block on empty wait")
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	mu.Lock()
+	assert.False(t, ready)
+	mu.Unlock()
+
+	require.NoError(t, rb.Push(new(P)))
+	select {
+	case err := <-doneCh:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Circular did not unblock wait on push")
+	}
+	assert.Equal(t, 1, rb.Length())
+
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	rb.Close()
+	mu.Lock()
+	assert.ErrorIs(t, rb.WaitUnlock(mu.Unlock), Closed)
+
+	assertWakesPop(t, func(rb *Circular[P, *P]) {
+		mu.Lock()
+		assert.NoError(t, rb.WaitUnlock(mu.Unlock))
+	})
+}
+
+func TestCircularClone(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircularWithOverflow[P, *P](3, DropOldest)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Push(&P{Int: 4}))
+
+	t.Run("shallow", func(t *testing.T) {
+		c := rb.Clone()
+		assert.Equal(t, DropOldest, c.overflow)
+		assert.Equal(t, rb.maxSize, c.maxSize)
+		assert.Equal(t, 3, c.Length())
+
+		for i := 2; i <= 4; i++ {
+			actual, err := c.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+		assert.Equal(t, 0, c.Length())
+		assert.Equal(t, 3, rb.Length())
+
+		first, err := rb.PeekBatch(1)
+		require.NoError(t, err)
+		c = rb.Clone()
+		cloned, err := c.PeekBatch(1)
+		require.NoError(t, err)
+		assert.Same(t, first[0], cloned[0])
+	})
+	t.Run("deep", func(t *testing.T) {
+		c := rb.CloneFunc(func(p *P) *P {
+			cp := *p
+			return &cp
+		})
+		actual, err := c.Pop()
+		require.NoError(t, err)
+		actual.Int = 42
+
+		original, err := rb.PeekBatch(1)
+		require.NoError(t, err)
+		assert.Equal(t, 2, original[0].Int)
+		assert.NotSame(t, original[0], actual)
+	})
+	t.Run("closed", func(t *testing.T) {
+		closed := NewCircular[P, *P](1)
+		require.NoError(t, closed.Push(new(P)))
+		closed.Close()
+		c := closed.Clone()
+		assert.False(t, c.IsClosed())
+		assert.Equal(t, 1, c.Length())
+	})
+	t.Run("options", func(t *testing.T) {
+		limited, err := NewCircularOpts[P, *P](WithMaxWaiters(1), WithRejectNil())
+		require.NoError(t, err)
+		pushed := 0
+		limited.OnPush(func(*P) { pushed++ })
+
+		c := limited.Clone()
+		assert.Equal(t, int64(1), c.maxWaiters)
+		assert.True(t, c.rejectNil)
+		require.NoError(t, c.Push(new(P)))
+		assert.Equal(t, 0, pushed)
+	})
+}
+
+func TestCircularPopBatchInto(t *testing.T) {
+	t.Parallel()
+
+	t.Run("partial batch", func(t *testing.T) {
+		rb := NewCircular[P, *P](8)
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		dst := make([]*P, 5)
+		n, err := rb.PopBatchInto(dst)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		for i := 0; i < n; i++ {
+			assert.Equal(t, i+1, dst[i].Int)
+		}
+		assert.Nil(t, dst[3])
+		assert.Equal(t, 0, rb.Length())
+	})
+	t.Run("writes at most len(dst)", func(t *testing.T) {
+		rb := NewCircular[P, *P](8)
+		for i := 1; i <= 5; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		dst := make([]*P, 2)
+		n, err := rb.PopBatchInto(dst)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, 1, dst[0].Int)
+		assert.Equal(t, 2, dst[1].Int)
+		assert.Equal(t, 3, rb.Length())
+
+		n, err = rb.PopBatchInto(nil)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+	t.Run("blocks for at least one", func(t *testing.T) {
+		rb := NewCircular[P, *P](8)
+		done := make(chan int, 1)
+		go func() {
+			n, _ := rb.PopBatchInto(make([]*P, 4))
+			done <- n
+		}()
+		select {
+		case <-done:
+			t.Fatal("PopBatchInto should block on an empty queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		assert.Equal(t, 1, <-done)
+		assert.Equal(t, uint64(1), rb.Stats().PopBlocks)
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](8)
+		done := make(chan error, 1)
+		go func() {
+			_, err := rb.PopBatchInto(make([]*P, 4))
+			done <- err
+		}()
+		rb.Close()
+		assert.ErrorIs(t, <-done, Closed)
+	})
+}
+
+func TestCircularDrainN(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](4)
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Push(&P{Int: 5}))
+	require.NoError(t, rb.Push(&P{Int: 6}))
+
+	items, more, err := rb.DrainN(3)
+	require.NoError(t, err)
+	assert.True(t, more)
+	require.Len(t, items, 3)
+	for i, p := range items {
+		assert.Equal(t, i+3, p.Int)
+	}
+	for _, p := range rb.nodes {
+		if p != nil {
+			assert.Equal(t, 6, p.Int)
+		}
+	}
+
+	items, more, err = rb.DrainN(3)
+	require.NoError(t, err)
+	assert.False(t, more)
+	require.Len(t, items, 1)
+	assert.Equal(t, 6, items[0].Int)
+
+	items, more, err = rb.DrainN(3)
+	require.NoError(t, err)
+	assert.False(t, more)
+	assert.Empty(t, items)
+
+	require.NoError(t, rb.Push(&P{Int: 7}))
+	items, more, err = rb.DrainN(0)
+	require.NoError(t, err)
+	assert.True(t, more)
+	assert.Empty(t, items)
+
+	rb.Close()
+	_, _, err = rb.DrainN(1)
+	assert.ErrorIs(t, err, Closed)
+}
+
+func TestCircularAt(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](3)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	for i := 0; i < 2; i++ {
+		_, err := rb.Pop()
+		require.NoError(t, err)
+	}
+	for i := 4; i <= 5; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	require.Less(t, rb.tail, rb.head, "buffer should be wrapped")
+
+	for i := 0; i < 3; i++ {
+		actual, err := rb.At(i)
+		require.NoError(t, err)
+		assert.Equal(t, i+3, actual.Int)
+	}
+	_, err := rb.At(3)
+	assert.ErrorIs(t, err, OutOfRangeError)
+	_, err = rb.At(-1)
+	assert.ErrorIs(t, err, OutOfRangeError)
+
+	require.NoError(t, rb.SetAt(2, &P{Int: 60}))
+	assert.ErrorIs(t, rb.SetAt(3, &P{}), OutOfRangeError)
+	assert.Equal(t, 3, rb.Length())
+
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	actual, err := rb.At(1)
+	require.NoError(t, err)
+	assert.Equal(t, 60, actual.Int)
+
+	rb.Close()
+	_, err = rb.At(0)
+	assert.ErrorIs(t, err, Closed)
+	assert.ErrorIs(t, rb.SetAt(0, &P{}), Closed)
+}
+
+func TestCircularPushFront(t *testing.T) {
+	t.Parallel()
+
+	t.Run("order", func(t *testing.T) {
+		rb := NewCircular[P, *P](7)
+		require.NoError(t, rb.Push(&P{Int: 3}))
+		require.NoError(t, rb.PushFront(&P{Int: 2}))
+		require.NoError(t, rb.Push(&P{Int: 4}))
+		require.NoError(t, rb.PushFront(&P{Int: 1}))
+		assert.Equal(t, 4, rb.Length())
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+
+		require.NoError(t, rb.PushFront(&P{Int: 0}))
+		for i := 0; i <= 4; i++ {
+			if i == 1 {
+				continue
+			}
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+		assert.Equal(t, 0, rb.Length())
+	})
+	t.Run("blocks when full", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		require.NoError(t, rb.Push(&P{Int: 2}))
+
+		done := make(chan error, 1)
+		go func() {
+			done <- rb.PushFront(&P{Int: 1})
+		}()
+		select {
+		case <-done:
+			t.Fatal("PushFront should block on a full queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 2, actual.Int)
+		require.NoError(t, <-done)
+		actual, err = rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+	})
+	t.Run("grows", func(t *testing.T) {
+		rb := NewCircularWithOverflow[P, *P](1, Grow)
+		require.NoError(t, rb.Push(&P{Int: 2}))
+		require.NoError(t, rb.PushFront(&P{Int: 1}))
+		for i := 1; i <= 2; i++ {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.Close()
+		assert.ErrorIs(t, rb.PushFront(&P{}), Closed)
+	})
+}
+
+func TestCircularWaitNonEmpty(t *testing.T) {
+	t.Parallel()
+
+	t.Run("available", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		require.NoError(t, rb.WaitNonEmpty(context.Background()))
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("wakes on push", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		done := make(chan error, 1)
+		go func() {
+			done <- rb.WaitNonEmpty(context.Background())
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaiterCount() == 1
+		}, time.Second, time.Millisecond)
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		require.NoError(t, <-done)
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("spurious wakeup", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		done := make(chan error, 1)
+		go func() {
+			done <- rb.WaitNonEmpty(context.Background())
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaiterCount() == 1
+		}, time.Second, time.Millisecond)
+
+		// Push an element and take it again before the waiter can run.
+		rb.lock.Lock()
+		rb.push(&P{Int: 1})
+		_, ok := rb.pop()
+		require.True(t, ok)
+		rb.lock.Unlock()
+
+		select {
+		case <-done:
+			t.Fatal("WaitNonEmpty should keep waiting on an empty queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+		assert.Equal(t, 1, rb.WaiterCount())
+		require.NoError(t, rb.Push(&P{Int: 2}))
+		require.NoError(t, <-done)
+	})
+	t.Run("context", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- rb.WaitNonEmpty(ctx)
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaiterCount() == 1
+		}, time.Second, time.Millisecond)
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+		assert.Equal(t, 0, rb.WaiterCount())
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		done := make(chan error, 1)
+		go func() {
+			done <- rb.WaitNonEmpty(context.Background())
+		}()
+		rb.Close()
+		assert.ErrorIs(t, <-done, Closed)
+	})
+}
+
+func TestCircularCompact(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircularWithOverflow[P, *P](3, Grow)
+	minSlots := rb.maxSize
+	for i := 0; i < 60; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	grown := rb.maxSize
+	require.Greater(t, grown, minSlots)
+
+	rb.Compact()
+	assert.Equal(t, grown, rb.maxSize, "compact should be a no-op while the queue is full")
+
+	for i := 0; i < 52; i++ {
+		_, err := rb.Pop()
+		require.NoError(t, err)
+	}
+	for i := 60; i < 66; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	require.Less(t, rb.tail, rb.head, "buffer should be wrapped")
+
+	rb.Compact()
+	assert.Equal(t, slots(14), rb.maxSize)
+	assert.Equal(t, 14, rb.Length())
+	assert.Equal(t, 14, rb.LengthApprox())
+	for i := 52; i < 66; i++ {
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, i, actual.Int)
+	}
+
+	rb.Compact()
+	assert.Equal(t, minSlots, rb.maxSize)
+	require.NoError(t, rb.Push(&P{Int: 1}))
+	actual, err := rb.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, 1, actual.Int)
+}
+
+func TestCircularToSlice(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](3)
+	assert.Empty(t, rb.ToSlice())
+	assert.Empty(t, rb.DrainSlice())
+
+	require.NoError(t, rb.Push(&P{Int: 1}))
+	require.NoError(t, rb.Push(&P{Int: 2}))
+	values := rb.ToSlice()
+	require.Len(t, values, 2)
+	assert.Equal(t, 1, values[0].Int)
+	assert.Equal(t, 2, values[1].Int)
+	assert.Equal(t, 2, rb.Length())
+
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Push(&P{Int: 3}))
+	require.NoError(t, rb.Push(&P{Int: 4}))
+	require.Less(t, rb.tail, rb.head, "buffer should be wrapped")
+
+	values = rb.ToSlice()
+	require.Len(t, values, 3)
+	for i, p := range values {
+		assert.Equal(t, i+2, p.Int)
+	}
+	values[0] = nil
+	actual, err := rb.At(0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, actual.Int)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rb.Push(&P{Int: 5})
+	}()
+	require.Eventually(t, func() bool {
+		return rb.WaiterCount() == 1
+	}, time.Second, time.Millisecond)
+
+	values = rb.DrainSlice()
+	require.Len(t, values, 3)
+	for i, p := range values {
+		assert.Equal(t, i+2, p.Int)
+	}
+	require.NoError(t, <-done)
+	values = rb.DrainSlice()
+	require.Len(t, values, 1)
+	assert.Equal(t, 5, values[0].Int)
+	assert.Equal(t, 0, rb.Length())
+}
+
+func TestCircularEqual(t *testing.T) {
+	t.Parallel()
+
+	eq := func(a *P, b *P) bool {
+		return *a == *b
+	}
+
+	a := NewCircular[P, *P](3)
+	b := NewCircular[P, *P](7)
+	assert.True(t, a.Equal(b, eq))
+	assert.True(t, a.Equal(a, eq))
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, a.Push(&P{Int: i}))
+	}
+	_, err := a.Pop()
+	require.NoError(t, err)
+	require.NoError(t, a.Push(&P{Int: 4}))
+	require.Less(t, a.tail, a.head, "buffer should be wrapped")
+
+	for i := 2; i <= 3; i++ {
+		require.NoError(t, b.Push(&P{Int: i}))
+	}
+	assert.False(t, a.Equal(b, eq), "different lengths")
+	assert.False(t, b.Equal(a, eq), "different lengths")
+
+	require.NoError(t, b.Push(&P{Int: 5}))
+	assert.False(t, a.Equal(b, eq), "different contents")
+	require.NoError(t, b.SetAt(2, &P{Int: 4}))
+	assert.True(t, a.Equal(b, eq))
+	assert.True(t, b.Equal(a, eq))
+	assert.False(t, a.Equal(b, nil), "different pointers")
+	assert.True(t, a.Equal(a.Clone(), nil))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			a.Equal(b, eq)
+		}()
+		go func() {
+			defer wg.Done()
+			b.Equal(a, eq)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCircularPopBatchBytes(t *testing.T) {
+	t.Parallel()
+
+	weigh := func(p *P) int {
+		return len(p.String)
+	}
+
+	rb := NewCircular[P, *P](8)
+	for _, s := range []string{"aaaa", "bb", "ccc", "dddddddddd", "e", "f"} {
+		require.NoError(t, rb.Push(&P{String: s}))
+	}
+
+	values, err := rb.PopBatchBytes(9, weigh)
+	require.NoError(t, err)
+	require.Len(t, values, 3)
+	assert.Equal(t, "aaaa", values[0].String)
+	assert.Equal(t, "bb", values[1].String)
+	assert.Equal(t, "ccc", values[2].String)
+
+	values, err = rb.PopBatchBytes(9, weigh)
+	require.NoError(t, err)
+	require.Len(t, values, 1, "an oversized element is returned on its own")
+	assert.Equal(t, "dddddddddd", values[0].String)
+
+	values, err = rb.PopBatchBytes(9, weigh)
+	require.NoError(t, err)
+	require.Len(t, values, 2, "the queue drains before the budget is reached")
+	assert.Equal(t, 0, rb.Length())
+
+	done := make(chan []*P, 1)
+	go func() {
+		values, _ := rb.PopBatchBytes(9, weigh)
+		done <- values
+	}()
+	select {
+	case <-done:
+		t.Fatal("PopBatchBytes should block on an empty queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.NoError(t, rb.Push(&P{String: "g"}))
+	assert.Len(t, <-done, 1)
+
+	rb.Close()
+	_, err = rb.PopBatchBytes(9, weigh)
+	assert.ErrorIs(t, err, Closed)
+}
+
+func TestCircularRemoveFunc(t *testing.T) {
+	t.Parallel()
+
+	even := func(p *P) bool {
+		return p.Int%2 == 0
+	}
+
+	rb := NewCircular[P, *P](7)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	for i := 0; i < 5; i++ {
+		_, err := rb.Pop()
+		require.NoError(t, err)
+	}
+	for i := 6; i < 12; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	require.Less(t, rb.tail, rb.head, "buffer should be wrapped")
+
+	removed, err := rb.RemoveFunc(even)
+	require.NoError(t, err)
+	require.Len(t, removed, 3)
+	for i, p := range removed {
+		assert.Equal(t, 6+i*2, p.Int)
+	}
+	assert.Equal(t, 4, rb.Length())
+	assert.Equal(t, 4, rb.LengthApprox())
+	for i, p := range rb.ToSlice() {
+		assert.Equal(t, 5+i*2, p.Int)
+	}
+	nonNil := 0
+	for _, p := range rb.nodes {
+		if p != nil {
+			nonNil++
+		}
+	}
+	assert.Equal(t, 4, nonNil)
+
+	removed, err = rb.RemoveFunc(even)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+
+	for i := 12; i < 15; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	for _, i := range []int{5, 7, 9, 11, 12, 13, 14} {
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, i, actual.Int)
+	}
+
+	rb.Close()
+	_, err = rb.RemoveFunc(even)
+	assert.ErrorIs(t, err, Closed)
+}
+
+func TestCircularTryPushPop(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](1)
+	_, ok, err := rb.TryPop()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = rb.TryPush(&P{Int: 1})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	head, tail := rb.head, rb.tail
+	ok, err = rb.TryPush(&P{Int: 2})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, head, rb.head)
+	assert.Equal(t, tail, rb.tail)
+
+	actual, ok, err := rb.TryPop()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, actual.Int)
+
+	t.Run("concurrent", func(t *testing.T) {
+		rb := NewCircular[P, *P](8)
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		pushed, popped := 0, 0
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					if ok, _ := rb.TryPush(&P{Int: j}); ok {
+						lock.Lock()
+						pushed++
+						lock.Unlock()
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					if _, ok, _ := rb.TryPop(); ok {
+						lock.Lock()
+						popped++
+						lock.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, pushed-popped, rb.Length())
+	})
+	t.Run("grow", func(t *testing.T) {
+		rb := NewCircularWithOverflow[P, *P](1, Grow)
+		for i := 0; i < 4; i++ {
+			ok, err := rb.TryPush(&P{Int: i})
+			require.NoError(t, err)
+			assert.True(t, ok)
+		}
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.Close()
+		_, err := rb.TryPush(&P{})
+		assert.ErrorIs(t, err, Closed)
+		_, _, err = rb.TryPop()
+		assert.ErrorIs(t, err, Closed)
+	})
+}
+
+func TestCircularPeek(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](4)
+	_, ok, err := rb.TryPeek()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	done := make(chan *P, 1)
+	go func() {
+		p, _ := rb.Peek()
+		done <- p
+	}()
+	select {
+	case <-done:
+		t.Fatal("Peek should block on an empty queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	p := &P{Int: 1}
+	require.NoError(t, rb.Push(p))
+	assert.Same(t, p, <-done)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 2; i <= 3; i++ {
+			_ = rb.Push(&P{Int: i})
+		}
+	}()
+	peeked, ok, err := rb.TryPeek()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	wg.Wait()
+
+	actual, err := rb.Pop()
+	require.NoError(t, err)
+	assert.Same(t, peeked, actual)
+	assert.Equal(t, 1, actual.Int)
+	assert.Equal(t, 2, rb.Length())
+
+	rb.Close()
+	_, err = rb.Peek()
+	assert.ErrorIs(t, err, Closed)
+	_, _, err = rb.TryPeek()
+	assert.ErrorIs(t, err, Closed)
+
+	assertWakesPop(t, func(rb *Circular[P, *P]) {
+		_, err := rb.Peek()
+		assert.NoError(t, err)
+	})
+}
+
+func TestCircularContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("pop deadline", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := rb.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 0, rb.WaiterCount())
+
+		p := &P{Int: 1}
+		require.NoError(t, rb.Push(p))
+		actual, err := rb.PopContext(ctx)
+		require.NoError(t, err, "an available element is returned even if ctx is done")
+		assert.Same(t, p, actual)
+	})
+	t.Run("push cancel", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		head, tail := rb.head, rb.tail
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- rb.PushContext(ctx, &P{Int: 2})
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaiterCount() == 1
+		}, time.Second, time.Millisecond)
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+		assert.Equal(t, head, rb.head)
+		assert.Equal(t, tail, rb.tail)
+		assert.Equal(t, 1, rb.Length())
+		assert.Equal(t, 0, rb.WaiterCount())
+	})
+	t.Run("cancel wakes only the cancelled waiter", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		ctx, cancel := context.WithCancel(context.Background())
+		first := make(chan error, 1)
+		go func() {
+			_, err := rb.PopContext(ctx)
+			first <- err
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaiterCount() == 1
+		}, time.Second, time.Millisecond)
+		second := make(chan *P, 1)
+		go func() {
+			p, _ := rb.Pop()
+			second <- p
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaiterCount() == 2
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		assert.ErrorIs(t, <-first, context.Canceled)
+		assert.Equal(t, 1, rb.WaiterCount())
+		assert.Len(t, rb.notEmpty.waiters, 1)
+
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		assert.Equal(t, 1, (<-second).Int)
+	})
+	t.Run("cancelled waits leave no waiters", func(t *testing.T) {
+		const waiters = 2000
+
+		for _, opts := range [][]Option{
+			{WithCapacity(1)},
+			{WithCapacity(1), WithMaxWaiters(8)},
+		} {
+			empty, err := NewCircularOpts[P, *P](opts...)
+			require.NoError(t, err)
+			full, err := NewCircularOpts[P, *P](opts...)
+			require.NoError(t, err)
+			require.NoError(t, full.Push(&P{}))
+
+			var wg sync.WaitGroup
+			wg.Add(waiters * 2)
+			for i := 0; i < waiters; i++ {
+				timeout := time.Duration(i%10) * 100 * time.Microsecond
+				go func() {
+					defer wg.Done()
+					ctx, cancel := context.WithTimeout(context.Background(), timeout)
+					defer cancel()
+					_, err := empty.PopContext(ctx)
+					if !errors.Is(err, ErrTooManyWaiters) {
+						assert.ErrorIs(t, err, context.DeadlineExceeded)
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					ctx, cancel := context.WithTimeout(context.Background(), timeout)
+					defer cancel()
+					err := full.PushContext(ctx, &P{})
+					if !errors.Is(err, ErrTooManyWaiters) {
+						assert.ErrorIs(t, err, context.DeadlineExceeded)
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, 0, empty.WaiterCount())
+			assert.Equal(t, 0, full.WaiterCount())
+			assert.Empty(t, empty.notEmpty.waiters)
+			assert.Empty(t, full.notFull.waiters)
+			assert.True(t, empty.IsEmpty())
+			assert.Equal(t, 1, full.Length())
+
+			// The queues still work once every waiter has given up.
+			require.NoError(t, empty.Push(&P{Int: 1}))
+			actual, err := empty.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, 1, actual.Int)
+			_, err = full.Pop()
+			require.NoError(t, err)
+			require.NoError(t, full.Push(&P{Int: 2}))
+		}
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		done := make(chan error, 1)
+		go func() {
+			_, err := rb.PopContext(context.Background())
+			done <- err
+		}()
+		rb.Close()
+		assert.ErrorIs(t, <-done, Closed)
+		assert.ErrorIs(t, rb.PushContext(context.Background(), &P{}), Closed)
+	})
+}
+
+func TestCircularTimeout(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](1)
+	_, err := rb.PopTimeout(10 * time.Millisecond)
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	p := &P{Int: 1}
+	require.NoError(t, rb.PushTimeout(p, 10*time.Millisecond))
+	assert.ErrorIs(t, rb.PushTimeout(&P{Int: 2}, 10*time.Millisecond), ErrTimeout)
+	assert.Equal(t, 1, rb.Length())
+
+	actual, err := rb.PopTimeout(10 * time.Millisecond)
+	require.NoError(t, err)
+	assert.Same(t, p, actual)
+
+	for i := 0; i < 100; i++ {
+		p := &P{Int: i}
+		go func() {
+			time.Sleep(time.Millisecond)
+			_ = rb.Push(p)
+		}()
+		actual, err := rb.PopTimeout(time.Millisecond)
+		if errors.Is(err, ErrTimeout) {
+			actual, err = rb.Pop()
+		}
+		require.NoError(t, err)
+		assert.Same(t, p, actual)
+	}
+	assert.Equal(t, 0, rb.WaiterCount())
+
+	rb.CloseWithCause(context.DeadlineExceeded)
+	_, err = rb.PopTimeout(time.Millisecond)
+	assert.ErrorIs(t, err, Closed)
+	assert.NotErrorIs(t, err, ErrTimeout)
+}
+
+func TestCircularPushNPopN(t *testing.T) {
+	t.Parallel()
+
+	items := make([]*P, 10)
+	for i := range items {
+		items[i] = &P{Int: i}
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		rb := NewCircular[P, *P](15)
+		pushed, err := rb.PushN(items)
+		require.NoError(t, err)
+		assert.Equal(t, 10, pushed)
+
+		values, err := rb.PopN(4)
+		require.NoError(t, err)
+		require.Len(t, values, 4)
+		values, err = rb.PopN(20)
+		require.NoError(t, err)
+		require.Len(t, values, 6)
+		for i, p := range values {
+			assert.Equal(t, i+4, p.Int)
+		}
+
+		values, err = rb.PopN(0)
+		require.NoError(t, err)
+		assert.Empty(t, values)
+	})
+	t.Run("push blocks until everything fits", func(t *testing.T) {
+		rb := NewCircular[P, *P](3)
+		done := make(chan int, 1)
+		go func() {
+			pushed, _ := rb.PushN(items)
+			done <- pushed
+		}()
+
+		next := 0
+		for next < len(items) {
+			values, err := rb.PopN(2)
+			require.NoError(t, err)
+			for _, p := range values {
+				assert.Equal(t, next, p.Int)
+				next++
+			}
+		}
+		assert.Equal(t, 10, <-done)
+	})
+	t.Run("pop blocks for at least one", func(t *testing.T) {
+		rb := NewCircular[P, *P](3)
+		done := make(chan []*P, 1)
+		go func() {
+			values, _ := rb.PopN(3)
+			done <- values
+		}()
+		select {
+		case <-done:
+			t.Fatal("PopN should block on an empty queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+		require.NoError(t, rb.Push(items[0]))
+		assert.Len(t, <-done, 1)
+	})
+	t.Run("closed mid-batch", func(t *testing.T) {
+		rb := NewCircular[P, *P](3)
+		done := make(chan error, 1)
+		var pushed int
+		go func() {
+			var err error
+			pushed, err = rb.PushN(items)
+			done <- err
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaiterCount() == 1
+		}, time.Second, time.Millisecond)
+		rb.Close()
+		assert.ErrorIs(t, <-done, Closed)
+		assert.Equal(t, 3, pushed)
+		_, err := rb.PopN(1)
+		assert.ErrorIs(t, err, Closed)
+	})
+}
+
+func TestCircularCap(t *testing.T) {
+	t.Parallel()
+
+	for _, size := range []uint64{0, 1, 3, 4, 100} {
+		rb := NewCircular[P, *P](size)
+		require.GreaterOrEqual(t, rb.Cap(), int(size))
+		assert.True(t, rb.IsEmpty())
+		for i := 0; i < rb.Cap(); i++ {
+			assert.False(t, rb.IsFull())
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		assert.True(t, rb.IsFull())
+		assert.False(t, rb.IsEmpty())
+		assert.Equal(t, rb.Cap(), rb.Length())
+	}
+	assert.Equal(t, 3, NewCircular[P, *P](3).Cap())
+
+	rb := NewCircularWithOverflow[P, *P](3, Grow)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	assert.Equal(t, 7, rb.Cap())
+}
+
+func TestCircularDrain(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](3)
+	assert.Nil(t, rb.Drain())
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Push(&P{Int: 4}))
+	require.Less(t, rb.tail, rb.head, "buffer should be wrapped")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rb.Push(&P{Int: 5})
+	}()
+	require.Eventually(t, func() bool {
+		return rb.WaiterCount() == 1
+	}, time.Second, time.Millisecond)
+
+	values := rb.Drain()
+	require.Len(t, values, 3)
+	for i, p := range values {
+		assert.Equal(t, i+2, p.Int)
+	}
+	require.NoError(t, <-done)
+	nonNil := 0
+	for _, p := range rb.nodes {
+		if p != nil {
+			nonNil++
+		}
+	}
+	assert.Equal(t, 1, nonNil, "drained slots should be cleared")
+
+	rb.Close()
+	values = rb.Drain()
+	require.Len(t, values, 1, "drain returns the remaining elements after close")
+	assert.Equal(t, 5, values[0].Int)
+	assert.Equal(t, 0, rb.Length())
+
+	t.Run("concurrent pops", func(t *testing.T) {
+		rb := NewCircular[P, *P](1024)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		seen := make(map[*P]struct{})
+		var lock sync.Mutex
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					p, ok, _ := rb.TryPop()
+					if !ok {
+						return
+					}
+					lock.Lock()
+					seen[p] = struct{}{}
+					lock.Unlock()
+				}
+			}()
+		}
+		drained := rb.Drain()
+		wg.Wait()
+		for _, p := range drained {
+			_, ok := seen[p]
+			assert.False(t, ok, "element returned by both Drain and Pop")
+			seen[p] = struct{}{}
+		}
+		assert.Len(t, seen, 1000)
+	})
+}
+
+func TestCircularCloseAndDrain(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](1024)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	pushed := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := rb.Push(new(P)); err != nil {
+					assert.ErrorIs(t, err, Closed)
+					return
+				}
+				lock.Lock()
+				pushed++
+				lock.Unlock()
+			}
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return rb.Length() > 0
+	}, time.Second, time.Millisecond)
+
+	values := rb.CloseAndDrain()
+	wg.Wait()
+	assert.True(t, rb.IsClosed())
+	assert.Equal(t, pushed, len(values), "every accepted element should be drained")
+	assert.Equal(t, 0, rb.Length())
+	assert.ErrorIs(t, rb.Push(new(P)), Closed)
+	assert.Nil(t, rb.CloseAndDrain())
+}
+
+func TestCircularOverwrite(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircularOverwrite[P, *P](2)
+	assert.Equal(t, 2, rb.Cap())
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+		assert.LessOrEqual(t, rb.Length(), 2)
+	}
+	assert.Equal(t, 2, rb.Length())
+	assert.Equal(t, 2, rb.LengthApprox())
+
+	evicted, err := rb.PushEvict(&P{Int: 5})
+	require.NoError(t, err)
+	assert.Equal(t, 3, evicted.Int)
+
+	for i := 4; i <= 5; i++ {
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, i, actual.Int)
+	}
+
+	evicted, err = rb.PushEvict(&P{Int: 6})
+	require.NoError(t, err)
+	assert.Nil(t, evicted)
+
+	for _, capacity := range []uint64{0, 1, 5} {
+		rb := NewCircularOverwrite[P, *P](capacity)
+		expected := int(capacity)
+		if expected == 0 {
+			expected = 1
+		}
+		assert.Equal(t, expected, rb.Cap())
+		for i := 0; i < 10; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		values := rb.Drain()
+		require.Len(t, values, expected)
+		for i, p := range values {
+			assert.Equal(t, 10-expected+i, p.Int)
+		}
+	}
+}
+
+func TestCircularResize(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](3)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Push(&P{Int: 4}))
+	require.Less(t, rb.tail, rb.head, "buffer should be wrapped")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rb.Push(&P{Int: 5})
+	}()
+	require.Eventually(t, func() bool {
+		return rb.WaiterCount() == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, rb.Resize(7))
+	assert.Equal(t, 7, rb.Cap())
+	require.NoError(t, <-done)
+	assert.Equal(t, 4, rb.Length())
+
+	assert.ErrorIs(t, rb.Resize(3), CapacityError)
+	assert.Equal(t, 7, rb.Cap())
+	assert.Equal(t, 4, rb.Length())
+
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Resize(3))
+	assert.Equal(t, 3, rb.Cap())
+	assert.True(t, rb.IsFull())
+	for i := 3; i <= 5; i++ {
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, i, actual.Int)
+	}
+
+	exact := NewCircularOverwrite[P, *P](2)
+	require.NoError(t, exact.Resize(5))
+	assert.Equal(t, 5, exact.Cap())
+
+	rb.Close()
+	assert.ErrorIs(t, rb.Resize(10), Closed)
+}
+
+func TestCircularC(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](4)
+	c := rb.C()
+	assert.Equal(t, c, rb.C())
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	for i := 1; i <= 3; i++ {
+		select {
+		case p := <-c:
+			assert.Equal(t, i, p.Int)
+		case <-time.After(time.Second):
+			t.Fatal("element was not delivered to the channel")
+		}
+	}
+
+	timeout := time.After(10 * time.Millisecond)
+	select {
+	case <-c:
+		t.Fatal("channel should not receive from an empty queue")
+	case <-timeout:
+	}
+
+	require.NoError(t, rb.Push(&P{Int: 4}))
+	require.Eventually(t, func() bool {
+		return rb.Length() == 0
+	}, time.Second, time.Millisecond, "the feeding goroutine should take the element")
+	rb.Close()
+
+	_, ok := <-c
+	assert.False(t, ok, "channel should be closed with the queue")
+	values := rb.Drain()
+	require.Len(t, values, 1, "an undelivered element should be put back")
+	assert.Equal(t, 4, values[0].Int)
+
+	closed := NewCircular[P, *P](1)
+	closed.Close()
+	_, ok = <-closed.C()
+	assert.False(t, ok)
+}
+
+func TestCircularStatsLockFree(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](4)
+	// Stats must not need the queue's lock.
+	rb.lock.Lock()
+	done := make(chan Stats, 1)
+	go func() {
+		done <- rb.Stats()
+	}()
+	select {
+	case stats := <-done:
+		assert.Equal(t, Stats{}, stats)
+	case <-time.After(time.Second):
+		t.Fatal("Stats blocked on the queue's lock")
+	}
+	rb.lock.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_ = rb.Push(new(P))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_, _ = rb.Pop()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		stats := rb.Stats()
+		assert.LessOrEqual(t, stats.PeakLength, rb.Cap())
+	}
+	wg.Wait()
+
+	stats := rb.Stats()
+	assert.Equal(t, uint64(1000), stats.Pushes)
+	assert.Equal(t, uint64(1000), stats.Pops)
+}
+
+func TestCircularCloseWait(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](1)
+	full := NewCircular[P, *P](1)
+	require.NoError(t, full.Push(new(P)))
+
+	var observed sync.WaitGroup
+	var lock sync.Mutex
+	returned := 0
+	for i := 0; i < 4; i++ {
+		observed.Add(1)
+		go func() {
+			defer observed.Done()
+			_, err := rb.Pop()
+			assert.ErrorIs(t, err, Closed)
+			lock.Lock()
+			returned++
+			lock.Unlock()
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return rb.WaiterCount() == 4
+	}, time.Second, time.Millisecond)
+
+	rb.CloseWait()
+	assert.Equal(t, 0, rb.WaiterCount())
+	observed.Wait()
+	assert.Equal(t, 4, returned)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- full.Push(new(P))
+	}()
+	require.Eventually(t, func() bool {
+		return full.WaiterCount() == 1
+	}, time.Second, time.Millisecond)
+	full.CloseWait()
+	assert.Equal(t, 0, full.WaiterCount())
+	assert.ErrorIs(t, <-done, Closed)
+
+	rb.CloseWait()
+}
+
+func TestCircularWaitingPushersPoppers(t *testing.T) {
+	t.Parallel()
+
+	empty := NewCircular[P, *P](1)
+	full := NewCircular[P, *P](1)
+	require.NoError(t, full.Push(new(P)))
+
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _ = empty.Pop()
+		}()
+	}
+	go func() {
+		_ = full.Push(new(P))
+	}()
+	require.Eventually(t, func() bool {
+		return empty.WaitingPoppers() == 2 && full.WaitingPushers() == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, empty.WaitingPushers())
+	assert.Equal(t, 0, full.WaitingPoppers())
+
+	// The counts can be read while the queue's lock is held elsewhere.
+	empty.lock.Lock()
+	assert.Equal(t, 2, empty.WaitingPoppers())
+	empty.lock.Unlock()
+
+	empty.CloseWait()
+	full.CloseWait()
+	assert.Equal(t, 0, empty.WaitingPoppers())
+	assert.Equal(t, 0, full.WaitingPushers())
+}
+
+func TestCircularPopBack(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](3)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Push(&P{Int: 4}))
+	require.NoError(t, rb.PushFront(&P{Int: 2}))
+	require.True(t, rb.IsFull())
+
+	actual, err := rb.PopBack()
+	require.NoError(t, err)
+	assert.Equal(t, 4, actual.Int)
+	assert.Equal(t, 2, rb.Length())
+	assert.Equal(t, 2, rb.LengthApprox())
+	actual, err = rb.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, 2, actual.Int)
+	actual, err = rb.PopBack()
+	require.NoError(t, err)
+	assert.Equal(t, 3, actual.Int)
+	assert.Equal(t, 0, rb.Length())
+
+	done := make(chan *P, 1)
+	go func() {
+		p, _ := rb.PopBack()
+		done <- p
+	}()
+	select {
+	case <-done:
+		t.Fatal("PopBack should block on an empty queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.NoError(t, rb.Push(&P{Int: 6}))
+	assert.Equal(t, 6, (<-done).Int)
+
+	t.Run("push front overwrites the back", func(t *testing.T) {
+		rb := NewCircularOverwrite[P, *P](2)
+		require.NoError(t, rb.Push(&P{Int: 2}))
+		require.NoError(t, rb.Push(&P{Int: 3}))
+		require.NoError(t, rb.PushFront(&P{Int: 1}))
+		assert.Equal(t, 2, rb.Length())
+		values := rb.Drain()
+		require.Len(t, values, 2)
+		assert.Equal(t, 1, values[0].Int)
+		assert.Equal(t, 2, values[1].Int)
+	})
+	t.Run("skips tombstoned elements", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		rb.SetSkipPredicate(func(p *P) bool {
+			return p.String == "cancelled"
+		})
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		require.NoError(t, rb.Push(&P{Int: 2}))
+		require.NoError(t, rb.Push(&P{Int: 3, String: "cancelled"}))
+		require.NoError(t, rb.Push(&P{Int: 4, String: "cancelled"}))
+
+		actual, err := rb.PopBack()
+		require.NoError(t, err)
+		assert.Equal(t, 2, actual.Int)
+		assert.Equal(t, uint64(2), rb.Skipped())
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.Close()
+		_, err := rb.PopBack()
+		assert.ErrorIs(t, err, Closed)
+	})
+}
+
+func TestCircularPopIf(t *testing.T) {
+	t.Parallel()
+
+	even := func(p *P) bool { return p.Int%2 == 0 }
+	rb := NewCircular[P, *P](4)
+	_, ok, err := rb.PopIf(even)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, rb.Push(&P{Int: 1}))
+	require.NoError(t, rb.Push(&P{Int: 2}))
+	_, ok, err = rb.PopIf(even)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 2, rb.Length())
+
+	actual, err := rb.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, 1, actual.Int)
+	actual, ok, err = rb.PopIf(even)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, actual.Int)
+	assert.Equal(t, 0, rb.Length())
+
+	t.Run("concurrent", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		require.NoError(t, rb.Push(&P{Int: 2}))
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		popped := 0
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, ok, _ := rb.PopIf(even); ok {
+					lock.Lock()
+					popped++
+					lock.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, popped)
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		rb.Close()
+		_, _, err := rb.PopIf(even)
+		assert.ErrorIs(t, err, Closed)
+	})
+}
+
+func TestCircularPopOk(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](4)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+
+	done := make(chan []int, 1)
+	go func() {
+		var values []int
+		for p, ok := rb.PopOk(); ok; p, ok = rb.PopOk() {
+			values = append(values, p.Int)
+		}
+		done <- values
+	}()
+	select {
+	case <-done:
+		t.Fatal("PopOk should block on an empty queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	rb.Close()
+	assert.Equal(t, []int{0, 1, 2}, <-done)
+
+	p, ok := rb.PopOk()
+	assert.False(t, ok)
+	assert.Nil(t, p)
+
+	t.Run("closed with elements left", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		rb.Close()
+
+		var values []int
+		for p, ok := rb.PopOk(); ok; p, ok = rb.PopOk() {
+			values = append(values, p.Int)
+		}
+		assert.Equal(t, []int{0, 1, 2}, values)
+		assert.True(t, rb.IsEmpty())
+	})
+}
+
+func TestCircularReset(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](4)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	nodes := rb.nodes
+	rb.CloseWithCause(errors.New("shutdown"))
+	rb.Reset()
+	assert.False(t, rb.IsClosed())
+	assert.True(t, rb.IsEmpty())
+	assert.Equal(t, 0, rb.LengthApprox())
+	assert.Equal(t, uint64(0), rb.head)
+	assert.Equal(t, uint64(0), rb.tail)
+	assert.Equal(t, &nodes[0], &rb.nodes[0])
+	for _, p := range rb.nodes {
+		assert.Nil(t, p)
+	}
+
+	require.NoError(t, rb.Push(&P{Int: 4}))
+	actual, err := rb.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, 4, actual.Int)
+
+	t.Run("channel", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		c := rb.C()
+		rb.Close()
+		_, ok := <-c
+		assert.False(t, ok)
+		rb.Reset()
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		assert.Equal(t, 1, (<-rb.C()).Int)
+		rb.Close()
+	})
+	t.Run("open channel", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		c := rb.C()
+		require.Eventually(t, func() bool {
+			return rb.WaitingPoppers() == 1
+		}, time.Second, time.Millisecond)
+
+		rb.Reset()
+		_, ok := <-c
+		assert.False(t, ok)
+		assert.False(t, rb.IsClosed())
+		assert.Equal(t, 0, rb.WaiterCount())
+
+		// The element the goroutine is trying to send is requeued and then
+		// dropped along with the rest of the queue.
+		c = rb.C()
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		require.Eventually(t, rb.IsEmpty, time.Second, time.Millisecond)
+		rb.Reset()
+		_, ok = <-c
+		assert.False(t, ok)
+		assert.True(t, rb.IsEmpty())
+
+		require.NoError(t, rb.Push(&P{Int: 2}))
+		assert.Equal(t, 2, (<-rb.C()).Int)
+		rb.Close()
+	})
+	t.Run("racing closed pop", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		for i := 0; i < 100; i++ {
+			rb.Close()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				p, ok, err := rb.TryPop()
+				assert.False(t, ok)
+				assert.Nil(t, p)
+				if err != nil {
+					assert.ErrorIs(t, err, Closed)
+				}
+			}()
+			rb.Reset()
+			<-done
+		}
+	})
+	t.Run("panics with waiters", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		done := make(chan struct{})
+		go func() {
+			_, _ = rb.Pop()
+			close(done)
+		}()
+		require.Eventually(t, func() bool {
+			return rb.WaitingPoppers() == 1
+		}, time.Second, time.Millisecond)
+		assert.Panics(t, rb.Reset)
+		rb.Close()
+		<-done
+	})
+}
+
+func TestCircularHighWaterMark(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](8)
+	assert.Equal(t, 0, rb.HighWaterMark())
+	for i := 0; i < 5; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	for i := 0; i < 3; i++ {
+		_, err := rb.Pop()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 5, rb.HighWaterMark())
+	assert.Equal(t, rb.Stats().PeakLength, rb.HighWaterMark())
+
+	require.NoError(t, rb.Push(&P{Int: 5}))
+	rb.ResetHighWaterMark()
+	assert.Equal(t, 3, rb.HighWaterMark())
+	assert.Equal(t, uint64(6), rb.Stats().Pushes)
+
+	require.NoError(t, rb.Push(&P{Int: 6}))
+	assert.Equal(t, 4, rb.HighWaterMark())
+}
+
+func TestCircularLayout(t *testing.T) {
+	t.Parallel()
+
+	// The producer writes tail and the consumer writes head, so they must sit
+	// on separate cache lines to avoid false sharing between the two.
+	var rb Circular[P, *P]
+	line := uintptr(cacheLinePadding * 8)
+	assert.GreaterOrEqual(t, unsafe.Offsetof(rb.head), line)
+	assert.GreaterOrEqual(t, unsafe.Offsetof(rb.tail)-unsafe.Offsetof(rb.head), line)
+	assert.GreaterOrEqual(t, unsafe.Offsetof(rb.maxSize)-unsafe.Offsetof(rb.tail), line)
+}
+
+func TestCircularHooks(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](3)
+	var pushed, popped []int
+	rb.OnPush(func(p *P) {
+		pushed = append(pushed, p.Int)
+		// Hooks run without the lock held, so they can use the queue.
+		_ = rb.Length()
+	})
+	rb.OnPop(func(p *P) {
+		popped = append(popped, p.Int)
+	})
+
+	require.NoError(t, rb.Push(&P{Int: 1}))
+	ok, err := rb.TryPush(&P{Int: 2})
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = rb.PushN([]*P{{Int: 3}})
+	require.NoError(t, err)
+	ok, err = rb.TryPush(&P{Int: 4})
+	require.NoError(t, err)
+	require.False(t, ok)
+	assert.Equal(t, []int{1, 2, 3}, pushed)
+
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	_, err = rb.PopN(1)
+	require.NoError(t, err)
+	_, _, err = rb.TryPop()
+	require.NoError(t, err)
+	_, ok, err = rb.TryPop()
+	require.NoError(t, err)
+	require.False(t, ok)
+	assert.Equal(t, []int{1, 2, 3}, popped)
+
+	require.NoError(t, rb.Push(&P{Int: 6}))
+	_ = rb.Drain()
+	assert.Equal(t, []int{1, 2, 3}, popped)
+
+	rb.OnPush(nil)
+	rb.OnPop(nil)
+	require.NoError(t, rb.Push(&P{Int: 7}))
+	_, err = rb.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 6}, pushed)
+	assert.Equal(t, []int{1, 2, 3}, popped)
+
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		calls := 0
+		rb.OnPush(func(*P) { calls++ })
+		rb.OnPop(func(*P) { calls++ })
+		rb.Close()
+		assert.ErrorIs(t, rb.Push(&P{}), Closed)
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+		assert.Equal(t, 0, calls)
+	})
+}
+
+func TestCircularFairness(t *testing.T) {
+	t.Parallel()
+
+	const consumers = 8
+	rb := NewCircular[P, *P](consumers)
+	received := make([]chan int, consumers)
+	for i := range received {
+		received[i] = make(chan int, 1)
+		go func(ch chan<- int) {
+			p, err := rb.Pop()
+			if err == nil {
+				ch <- p.Int
+			}
+		}(received[i])
+		require.Eventually(t, func() bool {
+			return rb.WaitingPoppers() == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	// Push every element back to back, so that woken consumers compete for
+	// the lock, and check that they are still served in the order they blocked.
+	for i := 0; i < consumers; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	for i, ch := range received {
+		select {
+		case actual := <-ch:
+			assert.Equal(t, i, actual)
+		case <-time.After(time.Second):
+			t.Fatalf("consumer %d did not receive an element", i)
+		}
+	}
+
+	t.Run("taken element keeps the waiter's place", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		received := make([]chan int, 2)
+		for i := range received {
+			received[i] = make(chan int, 1)
+			go func(ch chan<- int) {
+				p, err := rb.Pop()
+				if err == nil {
+					ch <- p.Int
+				}
+			}(received[i])
+			require.Eventually(t, func() bool {
+				return rb.WaitingPoppers() == i+1
+			}, time.Second, time.Millisecond)
+		}
+
+		// Take the element before the woken consumer gets to it, which
+		// sends that consumer back to waiting.
+		rb.lock.Lock()
+		rb.push(&P{Int: 0})
+		_, ok := rb.pop()
+		require.True(t, ok)
+		rb.lock.Unlock()
+		require.Eventually(t, func() bool {
+			rb.lock.Lock()
+			defer rb.lock.Unlock()
+			return !rb.popWaking
+		}, time.Second, time.Millisecond)
+
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		require.NoError(t, rb.Push(&P{Int: 2}))
+		assert.Equal(t, 1, <-received[0])
+		assert.Equal(t, 2, <-received[1])
+	})
+}
+
+func TestCircularSubscribe(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](16)
+	first, cancelFirst := rb.Subscribe(0)
+	second, cancelSecond := rb.Subscribe(0)
+	defer cancelFirst()
+	defer cancelSecond()
+
+	const count = 100
+	go func() {
+		for i := 0; i < count; i++ {
+			_ = rb.Push(&P{Int: i})
+		}
+	}()
+	seen := make(map[int]bool)
+	for len(seen) < count {
+		var p *P
+		select {
+		case p = <-first:
+		case p = <-second:
+		case <-time.After(time.Second):
+			t.Fatal("subscribers did not receive every element")
+		}
+		assert.False(t, seen[p.Int])
+		seen[p.Int] = true
+	}
+
+	t.Run("cancel returns undelivered elements", func(t *testing.T) {
+		rb := NewCircular[P, *P](8)
+		for i := 0; i < 4; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		ch, cancel := rb.Subscribe(2)
+		assert.Equal(t, 0, (<-ch).Int)
+		require.Eventually(t, func() bool {
+			return rb.Length() == 0
+		}, time.Second, time.Millisecond)
+		cancel()
+		cancel()
+		_, ok := <-ch
+		assert.False(t, ok)
+
+		values := rb.Drain()
+		require.Len(t, values, 3)
+		for i, p := range values {
+			assert.Equal(t, i+1, p.Int)
+		}
+	})
+	t.Run("cancel while waiting", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		ch, cancel := rb.Subscribe(1)
+		require.Eventually(t, func() bool {
+			return rb.WaitingPoppers() == 1
+		}, time.Second, time.Millisecond)
+		cancel()
+		_, ok := <-ch
+		assert.False(t, ok)
+		assert.Equal(t, 0, rb.WaitingPoppers())
+		assert.True(t, rb.IsEmpty())
+	})
+	t.Run("cancel on a full queue keeps its capacity", func(t *testing.T) {
+		for _, rb := range []*Circular[P, *P]{
+			NewCircular[P, *P](3),
+			NewCircularOverwrite[P, *P](3),
+		} {
+			ch, cancel := rb.Subscribe(1)
+			require.NoError(t, rb.Push(&P{Int: 0}))
+			require.NoError(t, rb.Push(&P{Int: 1}))
+			require.Eventually(t, func() bool {
+				return len(ch) == 1 && rb.Length() == 0
+			}, time.Second, time.Millisecond)
+			for i := 2; i <= 4; i++ {
+				require.NoError(t, rb.Push(&P{Int: i}))
+			}
+			require.True(t, rb.IsFull())
+
+			cancel()
+			assert.Equal(t, 5, rb.Length())
+			assert.Equal(t, 3, rb.Cap())
+			assert.True(t, rb.IsFull())
+			ok, err := rb.TryPush(&P{Int: 5})
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			for i := 0; i < 2; i++ {
+				actual, err := rb.Pop()
+				require.NoError(t, err)
+				assert.Equal(t, i, actual.Int)
+			}
+			assert.Equal(t, 3, rb.Cap())
+			assert.Equal(t, uint64(4), rb.maxSize)
+			assert.True(t, rb.IsFull())
+		}
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		ch, cancel := rb.Subscribe(0)
+		defer cancel()
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		assert.Equal(t, 1, (<-ch).Int)
+		rb.Close()
+		_, ok := <-ch
+		assert.False(t, ok)
+	})
+}
+
+func TestCircularClear(t *testing.T) {
+	t.Parallel()
+
+	rb := NewCircular[P, *P](3)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rb.Push(&P{Int: i}))
+	}
+	_, err := rb.Pop()
+	require.NoError(t, err)
+	require.NoError(t, rb.Push(&P{Int: 3}))
+	require.True(t, rb.IsFull())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rb.Push(&P{Int: 4})
+	}()
+	require.Eventually(t, func() bool {
+		return rb.WaitingPushers() == 1
+	}, time.Second, time.Millisecond)
+
+	rb.Clear()
+	require.NoError(t, <-done)
+	assert.Equal(t, 1, rb.Length())
+	assert.Equal(t, uint64(4), rb.Stats().Pops)
+	rb.lock.Lock()
+	for i, p := range rb.nodes {
+		if uint64(i) != rb.head {
+			assert.Nil(t, p)
+		}
+	}
+	rb.lock.Unlock()
+	actual, err := rb.Pop()
+	require.NoError(t, err)
+	assert.Equal(t, 4, actual.Int)
+
+	rb.Clear()
+	assert.Equal(t, 0, rb.Length())
+	assert.Equal(t, 0, rb.LengthApprox())
+	assert.Equal(t, uint64(0), rb.head)
+	assert.Equal(t, uint64(0), rb.tail)
+}
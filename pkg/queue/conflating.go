@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync"
+)
+
+// Conflating is a queue that only ever holds the most recently set element.
+//
+// Setting an element never blocks and replaces any element that has not
+// been consumed yet, while getting an element blocks until one has been set.
+// This is useful for propagating state where only the latest value matters.
+type Conflating[T any, P Pointer[T]] struct {
+	_padding0 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	lock      *sync.Mutex
+	_padding1 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	notEmpty  *sync.Cond
+	_padding2 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	value     P
+	_padding3 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	set       bool
+	_padding4 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	closed    bool
+}
+
+// NewConflatingQueue creates a new, empty conflating queue.
+func NewConflatingQueue[T any, P Pointer[T]]() *Conflating[T, P] {
+	q := new(Conflating[T, P])
+	q.lock = new(sync.Mutex)
+	q.notEmpty = sync.NewCond(q.lock)
+	return q
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *Conflating[T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.closed
+	q.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently and wakes up any blocked Get calls.
+// Like Circular.Close, it returns true if this call closed the queue.
+func (q *Conflating[T, P]) Close() (closed bool) {
+	q.lock.Lock()
+	closed = !q.closed
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.lock.Unlock()
+	return
+}
+
+// Set replaces the element in the queue with p. It never blocks.
+func (q *Conflating[T, P]) Set(p P) error {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return Closed
+	}
+	q.value = p
+	q.set = true
+	q.notEmpty.Signal()
+	q.lock.Unlock()
+	return nil
+}
+
+// Get removes and returns the most recently set element,
+// blocking until an element has been set.
+func (q *Conflating[T, P]) Get() (p P, err error) {
+	q.lock.Lock()
+LOOP:
+	if q.closed {
+		q.lock.Unlock()
+		return nil, Closed
+	}
+	if !q.set {
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+	p = q.value
+	q.value = nil
+	q.set = false
+	q.lock.Unlock()
+	return
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConflating(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		q := NewConflatingQueue[P, *P]()
+		p := &P{Int: 1}
+		require.NoError(t, q.Set(p))
+		actual, err := q.Get()
+		require.NoError(t, err)
+		assert.Equal(t, p, actual)
+	})
+	t.Run("latest value wins", func(t *testing.T) {
+		q := NewConflatingQueue[P, *P]()
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, q.Set(&P{Int: i}))
+		}
+		actual, err := q.Get()
+		require.NoError(t, err)
+		assert.Equal(t, 3, actual.Int)
+	})
+	t.Run("get blocks until set", func(t *testing.T) {
+		q := NewConflatingQueue[P, *P]()
+		doneCh := make(chan *P, 1)
+		go func() {
+			actual, err := q.Get()
+			assert.NoError(t, err)
+			doneCh <- actual
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Conflating did not block on empty read")
+		case <-time.After(time.Millisecond * 10):
+		}
+
+		p := &P{Int: 1}
+		require.NoError(t, q.Set(p))
+		select {
+		case actual := <-doneCh:
+			assert.Equal(t, p, actual)
+		case <-time.After(time.Second):
+			t.Fatal("Conflating did not unblock on set")
+		}
+	})
+	t.Run("get consumes the value", func(t *testing.T) {
+		q := NewConflatingQueue[P, *P]()
+		require.NoError(t, q.Set(&P{Int: 1}))
+		_, err := q.Get()
+		require.NoError(t, err)
+
+		doneCh := make(chan struct{}, 1)
+		go func() {
+			_, _ = q.Get()
+			doneCh <- struct{}{}
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Conflating returned a consumed value twice")
+		case <-time.After(time.Millisecond * 10):
+		}
+		q.Close()
+		<-doneCh
+	})
+	t.Run("closed", func(t *testing.T) {
+		q := NewConflatingQueue[P, *P]()
+		assert.False(t, q.IsClosed())
+
+		doneCh := make(chan error, 1)
+		go func() {
+			_, err := q.Get()
+			doneCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		q.Close()
+		assert.True(t, q.IsClosed())
+		select {
+		case err := <-doneCh:
+			assert.ErrorIs(t, err, Closed)
+		case <-time.After(time.Second):
+			t.Fatal("Conflating did not unblock on close")
+		}
+
+		assert.ErrorIs(t, q.Set(new(P)), Closed)
+	})
+}
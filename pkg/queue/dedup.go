@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+// Dedup is a blocking FIFO queue that holds at most one element per key.
+//
+// Pushing an element whose key is already in the queue is a no-op that returns
+// DuplicateError, and popping an element removes its key so that it can be
+// pushed again. This is useful for idempotent tasks that may be enqueued
+// multiple times before they are processed.
+type Dedup[K comparable, T any, P Pointer[T]] struct {
+	queue *Circular[T, P]
+	key   func(P) K
+	keys  map[K]struct{}
+}
+
+// NewDedupQueue creates a new deduplicating queue with the given size, using
+// key to compute the key of each element.
+func NewDedupQueue[K comparable, T any, P Pointer[T]](key func(P) K, maxSize uint64) *Dedup[K, T, P] {
+	return &Dedup[K, T, P]{
+		queue: NewCircular[T, P](maxSize),
+		key:   key,
+		keys:  make(map[K]struct{}),
+	}
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *Dedup[K, T, P]) IsClosed() bool {
+	return q.queue.IsClosed()
+}
+
+// Length returns the number of elements in the queue.
+func (q *Dedup[K, T, P]) Length() int {
+	return q.queue.Length()
+}
+
+// Close closes the queue permanently, and like Circular.Close, returns true
+// if this call closed the queue.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Dedup[K, T, P]) Close() bool {
+	return q.queue.Close()
+}
+
+// Push adds an element to the queue, blocking while the queue is full.
+// It returns DuplicateError without adding the element if an element
+// with the same key is already in the queue.
+func (q *Dedup[K, T, P]) Push(p P) error {
+	key := q.key(p)
+	q.queue.lock.Lock()
+LOOP:
+	if q.queue.isClosed() {
+		err := q.queue.err
+		q.queue.lock.Unlock()
+		return err
+	}
+	if _, ok := q.keys[key]; ok {
+		q.queue.lock.Unlock()
+		return DuplicateError
+	}
+	if q.queue.isFull() {
+		if err := q.queue.waitNotFull(); err != nil {
+			q.queue.lock.Unlock()
+			return err
+		}
+		goto LOOP
+	}
+
+	q.queue.push(p)
+	q.keys[key] = struct{}{}
+	q.queue.lock.Unlock()
+	return nil
+}
+
+// Pop removes an element from the queue, blocking while the queue is empty.
+func (q *Dedup[K, T, P]) Pop() (P, error) {
+	q.queue.lock.Lock()
+LOOP:
+	if q.queue.isClosed() {
+		err := q.queue.err
+		q.queue.lock.Unlock()
+		return nil, err
+	}
+	p, ok := q.queue.pop()
+	if !ok {
+		if err := q.queue.waitNotEmpty(); err != nil {
+			q.queue.lock.Unlock()
+			return nil, err
+		}
+		goto LOOP
+	}
+	delete(q.keys, q.key(p))
+	q.queue.lock.Unlock()
+	return p, nil
+}
+
+// Drain removes all elements from the queue
+// and returns them in a slice.
+//
+// This function should only be called after the queue is closed.
+func (q *Dedup[K, T, P]) Drain() []P {
+	q.queue.lock.Lock()
+	values := q.queue.drain()
+	for _, p := range values {
+		delete(q.keys, q.key(p))
+	}
+	q.queue.lock.Unlock()
+	return values
+}
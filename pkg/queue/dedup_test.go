@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedup(t *testing.T) {
+	t.Parallel()
+
+	key := func(p *P) int {
+		return p.Int
+	}
+
+	t.Run("success", func(t *testing.T) {
+		q := NewDedupQueue[int, P, *P](key, 4)
+		p := &P{Int: 1}
+		require.NoError(t, q.Push(p))
+		actual, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p, actual)
+	})
+	t.Run("duplicates are rejected until popped", func(t *testing.T) {
+		q := NewDedupQueue[int, P, *P](key, 4)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		require.NoError(t, q.Push(&P{Int: 2}))
+		assert.ErrorIs(t, q.Push(&P{Int: 1, String: "again"}), DuplicateError)
+		assert.Equal(t, 2, q.Length())
+
+		actual, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+		assert.Equal(t, "", actual.String)
+
+		require.NoError(t, q.Push(&P{Int: 1, String: "again"}))
+		assert.Equal(t, 2, q.Length())
+	})
+	t.Run("blocking", func(t *testing.T) {
+		q := NewDedupQueue[int, P, *P](key, 1)
+		require.NoError(t, q.Push(&P{Int: 1}))
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- q.Push(&P{Int: 2})
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Dedup did not block on full write")
+		case <-time.After(time.Millisecond * 10):
+		}
+
+		actual, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+		select {
+		case err := <-doneCh:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Dedup did not unblock on read from full write")
+		}
+	})
+	t.Run("drain", func(t *testing.T) {
+		q := NewDedupQueue[int, P, *P](key, 4)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		require.NoError(t, q.Push(&P{Int: 2}))
+		q.Close()
+		values := q.Drain()
+		assert.Len(t, values, 2)
+		assert.Empty(t, q.keys)
+	})
+	t.Run("closed", func(t *testing.T) {
+		q := NewDedupQueue[int, P, *P](key, 1)
+		assert.False(t, q.IsClosed())
+		q.Close()
+		assert.True(t, q.IsClosed())
+		assert.ErrorIs(t, q.Push(new(P)), Closed)
+		_, err := q.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+}
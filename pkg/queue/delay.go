@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Delay is a queue whose elements only become available to Pop once their
+// ready time has arrived. It is unbounded when created with NewDelayQueue, and
+// holds a limited number of elements when created with NewDelay.
+//
+// Elements are returned in order of their ready time, and elements with the
+// same ready time are returned in the order they were pushed. This is useful
+// for scheduling retries and timeouts.
+type Delay[T any, P Pointer[T]] struct {
+	lock    sync.Mutex
+	items   delayHeap[T, P]
+	maxSize int
+	seq     uint64
+	wake    chan struct{}
+	space   chan struct{}
+	closed  bool
+}
+
+type delayItem[T any, P Pointer[T]] struct {
+	value P
+	ready time.Time
+	seq   uint64
+}
+
+// delayHeap implements heap.Interface as a min-heap ordered by ready time.
+type delayHeap[T any, P Pointer[T]] []delayItem[T, P]
+
+func (h delayHeap[T, P]) Len() int { return len(h) }
+
+func (h delayHeap[T, P]) Less(i, j int) bool {
+	if h[i].ready.Equal(h[j].ready) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].ready.Before(h[j].ready)
+}
+
+func (h delayHeap[T, P]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap[T, P]) Push(x any) { *h = append(*h, x.(delayItem[T, P])) }
+
+func (h *delayHeap[T, P]) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = delayItem[T, P]{}
+	*h = old[:n-1]
+	return item
+}
+
+// NewDelayQueue creates a new, empty and unbounded delay queue.
+func NewDelayQueue[T any, P Pointer[T]]() *Delay[T, P] {
+	return &Delay[T, P]{
+		wake: make(chan struct{}),
+	}
+}
+
+// NewDelay creates a new, empty delay queue that holds up to capacity elements,
+// whether they are ready or not. A capacity of less than one is treated as one.
+func NewDelay[T any, P Pointer[T]](capacity int) *Delay[T, P] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Delay[T, P]{
+		items:   make(delayHeap[T, P], 0, capacity),
+		maxSize: capacity,
+		wake:    make(chan struct{}),
+		space:   make(chan struct{}),
+	}
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *Delay[T, P]) IsClosed() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.closed
+}
+
+// Length returns the number of elements in the queue, whether they are ready
+// or not.
+func (q *Delay[T, P]) Length() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.items)
+}
+
+// Close closes the queue permanently and wakes up any blocked Pop calls.
+// Like Circular.Close, it returns true if this call closed the queue.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Delay[T, P]) Close() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed = true
+	close(q.wake)
+	if q.space != nil {
+		close(q.space)
+	}
+	return true
+}
+
+// notify wakes up all blocked Pop calls so that they re-check the head of the
+// queue. It must be called with the lock held.
+func (q *Delay[T, P]) notify() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// isFull is an internal function used to check whether a bounded queue is
+// full. It must be called with the lock held.
+func (q *Delay[T, P]) isFull() bool {
+	return q.maxSize > 0 && len(q.items) >= q.maxSize
+}
+
+// popped is an internal function used to wake up all blocked Push calls after
+// an element was removed from a full queue. It must be called with the lock
+// held.
+func (q *Delay[T, P]) popped(wasFull bool) {
+	if wasFull && !q.closed {
+		close(q.space)
+		q.space = make(chan struct{})
+	}
+}
+
+// Push adds p to the queue, to become available to Pop at the given time,
+// blocking while the queue is full. An element whose ready time is earlier
+// than that of every element already in the queue wakes up blocked Pop calls,
+// so that they return it once it is ready instead of waiting for the later
+// element they were waiting for.
+func (q *Delay[T, P]) Push(p P, ready time.Time) error {
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return Closed
+		}
+		if !q.isFull() {
+			q.push(p, ready)
+			q.lock.Unlock()
+			return nil
+		}
+		space := q.space
+		q.lock.Unlock()
+		<-space
+	}
+}
+
+// PushAt adds p to the queue, to become available to Pop at the given time,
+// like Push. It never blocks, and returns FullError instead if the queue is
+// full.
+func (q *Delay[T, P]) PushAt(p P, ready time.Time) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return Closed
+	}
+	if q.isFull() {
+		return FullError
+	}
+	q.push(p, ready)
+	return nil
+}
+
+// push is an internal function used to add an element to the queue, which
+// must not be full. It must be called with the lock held.
+func (q *Delay[T, P]) push(p P, ready time.Time) {
+	seq := q.seq
+	q.seq++
+	heap.Push(&q.items, delayItem[T, P]{value: p, ready: ready, seq: seq})
+	if q.items[0].seq == seq {
+		// The head of the queue changed, so blocked poppers have to
+		// re-arm their timers.
+		q.notify()
+	}
+}
+
+// Pop removes and returns the element with the earliest ready time, blocking
+// until that time has arrived.
+func (q *Delay[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext is like Pop, but stops waiting and returns the context's error
+// once ctx is done.
+func (q *Delay[T, P]) PopContext(ctx context.Context) (P, error) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return nil, Closed
+		}
+		var ready <-chan time.Time
+		if len(q.items) > 0 {
+			wait := time.Until(q.items[0].ready)
+			if wait <= 0 {
+				wasFull := q.isFull()
+				item := heap.Pop(&q.items).(delayItem[T, P])
+				q.popped(wasFull)
+				q.lock.Unlock()
+				return item.value, nil
+			}
+			if timer == nil {
+				timer = time.NewTimer(wait)
+			} else {
+				timer.Reset(wait)
+			}
+			ready = timer.C
+		}
+		wake := q.wake
+		q.lock.Unlock()
+
+		select {
+		case <-ready:
+		case <-wake:
+			if timer != nil && !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Drain removes all elements from the queue, ready or not, and returns them
+// in order of their ready time.
+//
+// This function should only be called after the queue is closed.
+func (q *Delay[T, P]) Drain() []P {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.items) == 0 {
+		return nil
+	}
+	wasFull := q.isFull()
+	values := make([]P, 0, len(q.items))
+	for len(q.items) > 0 {
+		values = append(values, heap.Pop(&q.items).(delayItem[T, P]).value)
+	}
+	q.popped(wasFull)
+	return values
+}
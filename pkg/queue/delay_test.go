@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelay(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ready order", func(t *testing.T) {
+		q := NewDelayQueue[P, *P]()
+		now := time.Now()
+		require.NoError(t, q.PushAt(&P{Int: 3}, now.Add(-time.Millisecond)))
+		require.NoError(t, q.PushAt(&P{Int: 1}, now.Add(-3*time.Millisecond)))
+		require.NoError(t, q.PushAt(&P{Int: 2}, now.Add(-2*time.Millisecond)))
+		require.NoError(t, q.PushAt(&P{Int: 4}, now.Add(-time.Millisecond)))
+		assert.Equal(t, 4, q.Length())
+
+		for i := 1; i <= 4; i++ {
+			actual, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+		assert.Equal(t, 0, q.Length())
+	})
+	t.Run("blocks until ready", func(t *testing.T) {
+		q := NewDelayQueue[P, *P]()
+		start := time.Now()
+		require.NoError(t, q.PushAt(&P{Int: 1}, start.Add(20*time.Millisecond)))
+		actual, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	})
+	t.Run("earlier push re-arms", func(t *testing.T) {
+		q := NewDelayQueue[P, *P]()
+		require.NoError(t, q.PushAt(&P{Int: 2}, time.Now().Add(time.Hour)))
+
+		done := make(chan *P, 1)
+		go func() {
+			p, _ := q.Pop()
+			done <- p
+		}()
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, q.PushAt(&P{Int: 1}, time.Now().Add(10*time.Millisecond)))
+
+		select {
+		case actual := <-done:
+			assert.Equal(t, 1, actual.Int)
+		case <-time.After(time.Second):
+			t.Fatal("pop should return the element pushed to the head")
+		}
+		assert.Equal(t, 1, q.Length())
+	})
+	t.Run("context", func(t *testing.T) {
+		q := NewDelayQueue[P, *P]()
+		require.NoError(t, q.PushAt(&P{Int: 1}, time.Now().Add(time.Hour)))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := q.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, q.Length())
+	})
+	t.Run("close", func(t *testing.T) {
+		q := NewDelayQueue[P, *P]()
+		require.NoError(t, q.PushAt(&P{Int: 2}, time.Now().Add(2*time.Hour)))
+		require.NoError(t, q.PushAt(&P{Int: 1}, time.Now().Add(time.Hour)))
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := q.Pop()
+			done <- err
+		}()
+		q.Close()
+		q.Close()
+		assert.ErrorIs(t, <-done, Closed)
+		assert.True(t, q.IsClosed())
+		assert.ErrorIs(t, q.PushAt(&P{}, time.Now()), Closed)
+
+		values := q.Drain()
+		require.Len(t, values, 2)
+		assert.Equal(t, 1, values[0].Int)
+		assert.Equal(t, 2, values[1].Int)
+		assert.Nil(t, q.Drain())
+	})
+	t.Run("bounded", func(t *testing.T) {
+		q := NewDelay[P, *P](2)
+		now := time.Now()
+		require.NoError(t, q.Push(&P{Int: 2}, now.Add(-time.Millisecond)))
+		require.NoError(t, q.Push(&P{Int: 1}, now.Add(-2*time.Millisecond)))
+		assert.ErrorIs(t, q.PushAt(&P{Int: 3}, now), FullError)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Push(&P{Int: 3}, now)
+		}()
+		select {
+		case <-done:
+			t.Fatal("Push should block on a full queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+		for i := 1; i <= 3; i++ {
+			actual, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+			if i == 1 {
+				require.NoError(t, <-done)
+			}
+		}
+	})
+	t.Run("bounded preempts the current wait", func(t *testing.T) {
+		q := NewDelay[P, *P](2)
+		require.NoError(t, q.Push(&P{Int: 2}, time.Now().Add(time.Hour)))
+		done := make(chan *P, 1)
+		go func() {
+			p, _ := q.Pop()
+			done <- p
+		}()
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, q.Push(&P{Int: 1}, time.Now().Add(10*time.Millisecond)))
+		select {
+		case actual := <-done:
+			assert.Equal(t, 1, actual.Int)
+		case <-time.After(time.Second):
+			t.Fatal("pop should return the element pushed to the head")
+		}
+	})
+	t.Run("bounded close wakes blocked push", func(t *testing.T) {
+		q := NewDelay[P, *P](1)
+		require.NoError(t, q.Push(&P{Int: 1}, time.Now()))
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Push(&P{Int: 2}, time.Now())
+		}()
+		time.Sleep(10 * time.Millisecond)
+		q.Close()
+		assert.ErrorIs(t, <-done, Closed)
+		require.Len(t, q.Drain(), 1)
+	})
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"time"
+)
+
+// Lease is a blocking FIFO queue with at-least-once delivery.
+//
+// Popping an element with PopLease hands it out on a lease instead of removing
+// it outright. The element is only removed once the lease is acknowledged, and
+// is put back at the head of the queue, to be delivered again next, if the
+// lease is rejected or not acknowledged before the lease timeout.
+//
+// Leased elements keep their slot in the queue until they are acknowledged, so
+// requeueing an element never blocks, and every leased element is referenced
+// by the queue (along with a timer, if there is a lease timeout) until its
+// lease ends.
+type Lease[T any, P Pointer[T]] struct {
+	queue    *Circular[T, P]
+	timeout  time.Duration
+	inflight map[uint64]*lease[T, P]
+	next     uint64
+}
+
+// lease is an element that has been handed out by PopLease.
+type lease[T any, P Pointer[T]] struct {
+	value P
+	timer *time.Timer
+}
+
+// NewLeaseQueue creates a new lease queue with the given size, which counts
+// both queued and leased elements. Leases that are not acknowledged within the
+// given timeout are rejected automatically. A timeout of zero means leases
+// never expire.
+func NewLeaseQueue[T any, P Pointer[T]](maxSize uint64, timeout time.Duration) *Lease[T, P] {
+	return &Lease[T, P]{
+		queue:    NewCircular[T, P](maxSize),
+		timeout:  timeout,
+		inflight: make(map[uint64]*lease[T, P]),
+	}
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *Lease[T, P]) IsClosed() bool {
+	return q.queue.IsClosed()
+}
+
+// Length returns the number of elements in the queue that are waiting to be
+// leased.
+func (q *Lease[T, P]) Length() int {
+	return q.queue.Length()
+}
+
+// InFlight returns the number of elements that are currently leased.
+func (q *Lease[T, P]) InFlight() (n int) {
+	q.queue.lock.Lock()
+	n = len(q.inflight)
+	q.queue.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently, and like Circular.Close, returns true
+// if this call closed the queue.
+//
+// Leases that are still in flight can be acknowledged or rejected after the
+// queue is closed, and the Drain method can be used to drain the queue,
+// including any rejected elements.
+func (q *Lease[T, P]) Close() bool {
+	return q.queue.Close()
+}
+
+// Push adds an element to the queue, blocking while the queue is full.
+func (q *Lease[T, P]) Push(p P) error {
+	q.queue.lock.Lock()
+LOOP:
+	if q.queue.isClosed() {
+		err := q.queue.err
+		q.queue.lock.Unlock()
+		return err
+	}
+	if q.queue.length()+len(q.inflight) >= int(q.queue.capacity()) {
+		if err := q.queue.waitNotFull(); err != nil {
+			q.queue.lock.Unlock()
+			return err
+		}
+		goto LOOP
+	}
+
+	q.queue.push(p)
+	q.queue.lock.Unlock()
+	return nil
+}
+
+// PopLease leases the element at the head of the queue, blocking while the
+// queue is empty.
+//
+// The element is removed once ack is called, and put back at the head of the
+// queue once nack is called or the lease times out. Only the first call to
+// either function has any effect.
+func (q *Lease[T, P]) PopLease() (p P, ack func(), nack func(), err error) {
+	q.queue.lock.Lock()
+LOOP:
+	if q.queue.isClosed() {
+		err = q.queue.err
+		q.queue.lock.Unlock()
+		return nil, nil, nil, err
+	}
+	p, ok := q.queue.pop()
+	if !ok {
+		if err := q.queue.waitNotEmpty(); err != nil {
+			q.queue.lock.Unlock()
+			return nil, nil, nil, err
+		}
+		goto LOOP
+	}
+
+	id := q.next
+	q.next++
+	l := &lease[T, P]{value: p}
+	if q.timeout > 0 {
+		l.timer = time.AfterFunc(q.timeout, func() {
+			q.release(id, true)
+		})
+	}
+	q.inflight[id] = l
+	q.queue.lock.Unlock()
+
+	ack = func() {
+		q.release(id, false)
+	}
+	nack = func() {
+		q.release(id, true)
+	}
+	return p, ack, nack, nil
+}
+
+// release is an internal function used to end the lease with the given id,
+// putting its element back at the head of the queue if requeue is true.
+func (q *Lease[T, P]) release(id uint64, requeue bool) {
+	q.queue.lock.Lock()
+	l, ok := q.inflight[id]
+	if ok {
+		delete(q.inflight, id)
+		if l.timer != nil {
+			l.timer.Stop()
+		}
+		if requeue {
+			// The lease kept the element's slot reserved, so the
+			// queue has room for it.
+			q.queue.pushFront(l.value)
+		} else {
+			q.queue.notFull.Signal()
+		}
+	}
+	q.queue.lock.Unlock()
+}
+
+// Drain removes all elements that are waiting to be leased from the queue
+// and returns them in a slice. Elements that are still leased are not affected.
+//
+// This function should only be called after the queue is closed.
+func (q *Lease[T, P]) Drain() []P {
+	q.queue.lock.Lock()
+	values := q.queue.drain()
+	q.queue.lock.Unlock()
+	return values
+}
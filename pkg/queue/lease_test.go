@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ack", func(t *testing.T) {
+		q := NewLeaseQueue[P, *P](4, 0)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		require.NoError(t, q.Push(&P{Int: 2}))
+
+		p, ack, nack, err := q.PopLease()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+		assert.Equal(t, 1, q.InFlight())
+		assert.Equal(t, 1, q.Length())
+
+		ack()
+		nack()
+		assert.Equal(t, 0, q.InFlight())
+		assert.Equal(t, 1, q.Length())
+
+		p, _, _, err = q.PopLease()
+		require.NoError(t, err)
+		assert.Equal(t, 2, p.Int)
+	})
+	t.Run("nack requeues at the head", func(t *testing.T) {
+		q := NewLeaseQueue[P, *P](4, 0)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		require.NoError(t, q.Push(&P{Int: 2}))
+
+		p, _, nack, err := q.PopLease()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+		nack()
+		assert.Equal(t, 0, q.InFlight())
+		assert.Equal(t, 2, q.Length())
+
+		for i := 1; i <= 2; i++ {
+			p, ack, _, err := q.PopLease()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+			ack()
+		}
+	})
+	t.Run("timeout requeues", func(t *testing.T) {
+		q := NewLeaseQueue[P, *P](4, 20*time.Millisecond)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		require.NoError(t, q.Push(&P{Int: 2}))
+		require.NoError(t, q.Push(&P{Int: 3}))
+
+		p, _, _, err := q.PopLease()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+		p, ack, _, err := q.PopLease()
+		require.NoError(t, err)
+		assert.Equal(t, 2, p.Int)
+		ack()
+
+		require.Eventually(t, func() bool {
+			return q.InFlight() == 0
+		}, time.Second, time.Millisecond)
+
+		for i := 1; i <= 3; i += 2 {
+			p, ack, _, err := q.PopLease()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+			ack()
+		}
+		assert.Equal(t, 0, q.Length())
+	})
+	t.Run("leases reserve capacity", func(t *testing.T) {
+		q := NewLeaseQueue[P, *P](1, 0)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		_, ack, _, err := q.PopLease()
+		require.NoError(t, err)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Push(&P{Int: 2})
+		}()
+		select {
+		case <-done:
+			t.Fatal("Push should block while a lease holds the only slot")
+		case <-time.After(10 * time.Millisecond):
+		}
+		ack()
+		require.NoError(t, <-done)
+		assert.Equal(t, 1, q.Length())
+	})
+	t.Run("close", func(t *testing.T) {
+		q := NewLeaseQueue[P, *P](4, 0)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		_, _, nack, err := q.PopLease()
+		require.NoError(t, err)
+
+		q.Close()
+		assert.True(t, q.IsClosed())
+		_, _, _, err = q.PopLease()
+		assert.ErrorIs(t, err, Closed)
+		assert.ErrorIs(t, q.Push(&P{}), Closed)
+
+		nack()
+		values := q.Drain()
+		require.Len(t, values, 1)
+		assert.Equal(t, 1, values[0].Int)
+	})
+}
@@ -177,9 +177,10 @@ DONE:
 }
 
 // Close marks the LockFree as closed, returns any waiting Pop() calls,
-// and blocks all future Push calls from occurring.
-func (q *LockFree[T, P]) Close() {
-	atomic.CompareAndSwapUint64(&q.closed, 0, 1)
+// and blocks all future Push calls from occurring. It returns true if
+// this call was the one that closed the LockFree.
+func (q *LockFree[T, P]) Close() bool {
+	return atomic.CompareAndSwapUint64(&q.closed, 0, 1)
 }
 
 // IsClosed returns whether the LockFree has been closed
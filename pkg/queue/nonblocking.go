@@ -104,13 +104,16 @@ func (q *NonBlocking[T, P]) length() int {
 	return int(q.tail - q.head)
 }
 
-// Close closes the queue permanently.
+// Close closes the queue permanently, and like Circular.Close, returns true
+// if this call closed the queue.
 //
 // The Drain method can be used to drain the queue after it is closed.
-func (q *NonBlocking[T, P]) Close() {
+func (q *NonBlocking[T, P]) Close() (closed bool) {
 	q.lock.Lock()
+	closed = !q.closed
 	q.closed = true
 	q.lock.Unlock()
+	return
 }
 
 // Push adds an element to the queue.
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+)
+
+// notifier is a condition variable like sync.Cond that wakes up waiters in
+// FIFO order and supports waiting with a context.
+//
+// Each waiter blocks on its own channel, so a waiter whose context is done
+// can stop waiting without waking up any other waiter.
+type notifier struct {
+	lock    sync.Locker
+	waiters []chan struct{}
+}
+
+// waiterPool holds the channels of waiters that are done waiting. Every
+// channel has a buffer of one, so that waking up a waiter never blocks.
+var waiterPool = sync.Pool{
+	New: func() any {
+		return make(chan struct{}, 1)
+	},
+}
+
+func newNotifier(lock sync.Locker) *notifier {
+	return &notifier{lock: lock}
+}
+
+// Wait unlocks the lock and blocks until woken up by Signal or Broadcast,
+// then locks the lock again before returning. The lock must be held when
+// calling Wait.
+func (n *notifier) Wait() {
+	_ = n.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait, but stops waiting once ctx is done and returns the
+// context's error. The lock is held again when WaitContext returns either way.
+//
+// If the waiter is woken up at the same time as ctx is done, the wakeup wins
+// and WaitContext returns nil, so that wakeups are never lost.
+func (n *notifier) WaitContext(ctx context.Context) error {
+	return n.wait(ctx, false)
+}
+
+// WaitFirstContext is like WaitContext, but waits ahead of every other waiter
+// instead of behind them, so that a waiter that was woken up for nothing can
+// wait again without losing its place.
+func (n *notifier) WaitFirstContext(ctx context.Context) error {
+	return n.wait(ctx, true)
+}
+
+// wait is an internal function used to implement WaitContext and
+// WaitFirstContext.
+func (n *notifier) wait(ctx context.Context, first bool) error {
+	ch := waiterPool.Get().(chan struct{})
+	if first {
+		n.waiters = append(n.waiters, nil)
+		copy(n.waiters[1:], n.waiters)
+		n.waiters[0] = ch
+	} else {
+		n.waiters = append(n.waiters, ch)
+	}
+	n.lock.Unlock()
+
+	var err error
+	select {
+	case <-ch:
+		n.lock.Lock()
+	case <-ctx.Done():
+		n.lock.Lock()
+		if n.remove(ch) {
+			err = ctx.Err()
+		} else {
+			// We were woken up before we could remove ourselves.
+			<-ch
+		}
+	}
+	waiterPool.Put(ch)
+	return err
+}
+
+// remove is an internal function used to remove a waiter from the list of
+// waiters, and returns false if it was already woken up.
+func (n *notifier) remove(ch chan struct{}) bool {
+	for i, waiter := range n.waiters {
+		if waiter == ch {
+			copy(n.waiters[i:], n.waiters[i+1:])
+			n.waiters[len(n.waiters)-1] = nil
+			n.waiters = n.waiters[:len(n.waiters)-1]
+			return true
+		}
+	}
+	return false
+}
+
+// Signal wakes up the goroutine that has been waiting the longest, if any,
+// and returns false if there was none. The lock must be held when calling
+// Signal.
+func (n *notifier) Signal() bool {
+	if len(n.waiters) == 0 {
+		return false
+	}
+	n.waiters[0] <- struct{}{}
+	n.waiters[0] = nil
+	n.waiters = n.waiters[1:]
+	return true
+}
+
+// Broadcast wakes up all waiting goroutines. The lock must be held when
+// calling Broadcast.
+func (n *notifier) Broadcast() {
+	for i, waiter := range n.waiters {
+		waiter <- struct{}{}
+		n.waiters[i] = nil
+	}
+	n.waiters = n.waiters[:0]
+}
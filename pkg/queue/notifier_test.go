@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier(t *testing.T) {
+	t.Parallel()
+
+	waiting := func(lock *sync.Mutex, n *notifier) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(n.waiters)
+	}
+
+	t.Run("signal wakes waiters in order", func(t *testing.T) {
+		lock := new(sync.Mutex)
+		n := newNotifier(lock)
+		woken := make(chan int, 3)
+		for i := 0; i < 3; i++ {
+			i := i
+			go func() {
+				lock.Lock()
+				n.Wait()
+				woken <- i
+				lock.Unlock()
+			}()
+			require.Eventually(t, func() bool {
+				return waiting(lock, n) == i+1
+			}, time.Second, time.Millisecond)
+		}
+		for i := 0; i < 3; i++ {
+			lock.Lock()
+			n.Signal()
+			lock.Unlock()
+			assert.Equal(t, i, <-woken)
+		}
+	})
+	t.Run("broadcast", func(t *testing.T) {
+		lock := new(sync.Mutex)
+		n := newNotifier(lock)
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lock.Lock()
+				n.Wait()
+				lock.Unlock()
+			}()
+		}
+		require.Eventually(t, func() bool {
+			return waiting(lock, n) == 3
+		}, time.Second, time.Millisecond)
+		lock.Lock()
+		n.Broadcast()
+		lock.Unlock()
+		wg.Wait()
+	})
+	t.Run("context", func(t *testing.T) {
+		lock := new(sync.Mutex)
+		n := newNotifier(lock)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		lock.Lock()
+		assert.ErrorIs(t, n.WaitContext(ctx), context.DeadlineExceeded)
+		assert.Empty(t, n.waiters)
+		lock.Unlock()
+	})
+	t.Run("wait first", func(t *testing.T) {
+		lock := new(sync.Mutex)
+		n := newNotifier(lock)
+		woken := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			i := i
+			go func() {
+				lock.Lock()
+				if i == 0 {
+					_ = n.WaitContext(context.Background())
+				} else {
+					_ = n.WaitFirstContext(context.Background())
+				}
+				woken <- i
+				lock.Unlock()
+			}()
+			require.Eventually(t, func() bool {
+				return waiting(lock, n) == i+1
+			}, time.Second, time.Millisecond)
+		}
+		for _, i := range []int{1, 0} {
+			lock.Lock()
+			assert.True(t, n.Signal())
+			lock.Unlock()
+			assert.Equal(t, i, <-woken)
+		}
+		lock.Lock()
+		assert.False(t, n.Signal())
+		lock.Unlock()
+	})
+}
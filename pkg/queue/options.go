@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"fmt"
+)
+
+// Option configures a queue created with NewCircularOpts.
+type Option func(*options)
+
+// options holds the configuration of a queue created with NewCircularOpts.
+type options struct {
+	capacity    uint64
+	overflow    Overflow
+	maxCapacity uint64
+	rejectNil   bool
+	lazy        bool
+	maxWaiters  int
+
+	// exact is set by NewCircularOverwrite so that the queue holds exactly
+	// capacity elements, instead of rounding up to a power of two.
+	exact bool
+}
+
+// WithCapacity sets the number of elements the queue can hold.
+//
+// If the queue uses the Grow overflow policy, this is the initial capacity.
+func WithCapacity(capacity uint64) Option {
+	return func(o *options) {
+		o.capacity = capacity
+	}
+}
+
+// WithOverflowPolicy sets the policy the queue follows when an element
+// is pushed while it is full. The default is Block.
+func WithOverflowPolicy(overflow Overflow) Option {
+	return func(o *options) {
+		o.overflow = overflow
+	}
+}
+
+// WithMaxCapacity sets the maximum number of elements a queue that uses
+// the Grow overflow policy can grow to hold. Without it the queue can grow
+// without bound.
+func WithMaxCapacity(maxCapacity uint64) Option {
+	return func(o *options) {
+		o.maxCapacity = maxCapacity
+	}
+}
+
+// WithRejectNil makes the queue reject nil elements, returning ErrNilElement
+// instead of storing them, so that consumers never receive a nil element.
+// By default nil elements are accepted like any other element.
+func WithRejectNil() Option {
+	return func(o *options) {
+		o.rejectNil = true
+	}
+}
+
+// WithLazyAllocation defers allocating the queue's backing array until the
+// first element is pushed, which saves memory for queues that are often
+// created but rarely used. The capacity set with WithCapacity is used as
+// usual once the array is allocated.
+func WithLazyAllocation() Option {
+	return func(o *options) {
+		o.lazy = true
+	}
+}
+
+// WithMaxWaiters limits the number of goroutines that can be blocked waiting
+// for space in the queue, and separately the number that can be blocked
+// waiting for an element, to n each. Once n goroutines are blocked in Push, a
+// further Push that would have to wait returns ErrTooManyWaiters instead, and
+// the same goes for Pop. This caps the number of goroutines that pile up when
+// producers or consumers stall. The default of zero means no limit.
+//
+// Circular.PopOk is the exception: it has no way to return the error, so it
+// is never rejected, though it still counts towards the limit.
+func WithMaxWaiters(n int) Option {
+	return func(o *options) {
+		o.maxWaiters = n
+	}
+}
+
+// validate checks that the options are valid and do not conflict with each other.
+func (o *options) validate() error {
+	switch o.overflow {
+	case Block, DropOldest, Grow:
+	default:
+		return fmt.Errorf("%w: unknown overflow policy %d", InvalidOptionsError, o.overflow)
+	}
+	if o.maxWaiters < 0 {
+		return fmt.Errorf("%w: maximum number of waiters %d is negative", InvalidOptionsError, o.maxWaiters)
+	}
+	if o.maxCapacity > 0 {
+		if o.overflow != Grow {
+			return fmt.Errorf("%w: a maximum capacity requires the Grow overflow policy", InvalidOptionsError)
+		}
+		if o.maxCapacity < o.capacity {
+			return fmt.Errorf("%w: maximum capacity %d is less than capacity %d", InvalidOptionsError, o.maxCapacity, o.capacity)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircularOpts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("defaults", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P]()
+		require.NoError(t, err)
+		assert.Equal(t, Block, rb.overflow)
+		assert.Equal(t, uint64(2), rb.maxSize)
+	})
+	t.Run("capacity and overflow policy", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithCapacity(3), WithOverflowPolicy(DropOldest))
+		require.NoError(t, err)
+		assert.Equal(t, DropOldest, rb.overflow)
+		assert.Equal(t, uint64(4), rb.maxSize)
+	})
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewCircularOpts[P, *P](WithOverflowPolicy(Overflow(42)))
+		assert.ErrorIs(t, err, InvalidOptionsError)
+
+		_, err = NewCircularOpts[P, *P](WithCapacity(4), WithMaxCapacity(16))
+		assert.ErrorIs(t, err, InvalidOptionsError)
+
+		_, err = NewCircularOpts[P, *P](WithCapacity(16), WithMaxCapacity(4), WithOverflowPolicy(Grow))
+		assert.ErrorIs(t, err, InvalidOptionsError)
+
+		_, err = NewCircularOpts[P, *P](WithMaxWaiters(-1))
+		assert.ErrorIs(t, err, InvalidOptionsError)
+	})
+	t.Run("grow", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithCapacity(1), WithOverflowPolicy(Grow), WithMaxCapacity(7))
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		_, err = rb.Pop()
+		require.NoError(t, err)
+		for i := 3; i < 8; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		assert.Equal(t, 7, rb.Length())
+		assert.Equal(t, uint64(8), rb.maxSize)
+
+		doneCh := make(chan struct{}, 1)
+		go func() {
+			assert.NoError(t, rb.Push(&P{Int: 8}))
+			doneCh <- struct{}{}
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Circular did not block at its maximum capacity")
+		case <-time.After(time.Millisecond * 10):
+		}
+
+		for i := 1; i < 9; i++ {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+		<-doneCh
+	})
+	t.Run("grow unbounded", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithOverflowPolicy(Grow))
+		require.NoError(t, err)
+
+		items := make([]*P, 100)
+		for i := range items {
+			items[i] = &P{Int: i}
+		}
+		accepted, err := rb.PushSome(items)
+		require.NoError(t, err)
+		assert.Equal(t, 100, accepted)
+		for i := 0; i < 100; i++ {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+	})
+	t.Run("reject nil", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithCapacity(4), WithRejectNil())
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, rb.Push(nil), ErrNilElement)
+		assert.ErrorIs(t, rb.PushFront(nil), ErrNilElement)
+		assert.Equal(t, 0, rb.Length())
+
+		accepted, err := rb.PushSome([]*P{{Int: 1}, nil, {Int: 2}})
+		assert.ErrorIs(t, err, ErrNilElement)
+		assert.Equal(t, 1, accepted)
+		assert.Equal(t, 1, rb.Length())
+
+		_, err = rb.ReplaceHead(nil)
+		assert.ErrorIs(t, err, ErrNilElement)
+		assert.ErrorIs(t, rb.SetAt(0, nil), ErrNilElement)
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+	})
+	t.Run("nil allowed by default", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithCapacity(4))
+		require.NoError(t, err)
+		require.NoError(t, rb.Push(nil))
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("lazy allocation", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithCapacity(1024), WithLazyAllocation())
+		require.NoError(t, err)
+		assert.Nil(t, rb.nodes)
+		assert.Equal(t, 0, rb.Length())
+		assert.True(t, rb.IsEmpty())
+		assert.False(t, rb.IsFull())
+		assert.Empty(t, rb.Drain())
+		rb.Compact()
+		assert.Nil(t, rb.nodes)
+
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		assert.Len(t, rb.nodes, 2048)
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+
+		for _, push := range []func(rb *Circular[P, *P]) error{
+			func(rb *Circular[P, *P]) error { return rb.PushFront(&P{Int: 1}) },
+			func(rb *Circular[P, *P]) error { _, err := rb.PushSome([]*P{{Int: 1}}); return err },
+			func(rb *Circular[P, *P]) error {
+				src := NewCircular[P, *P](1)
+				if err := src.Push(&P{Int: 1}); err != nil {
+					return err
+				}
+				_, err := src.DrainTo(rb)
+				return err
+			},
+		} {
+			rb, err := NewCircularOpts[P, *P](WithCapacity(4), WithLazyAllocation())
+			require.NoError(t, err)
+			require.NoError(t, push(rb))
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, 1, actual.Int)
+		}
+	})
+	t.Run("lazy allocation with growth", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithCapacity(1), WithOverflowPolicy(Grow), WithLazyAllocation())
+		require.NoError(t, err)
+		for i := 0; i < 4; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		for i := 0; i < 4; i++ {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+	})
+	t.Run("max waiters on pop", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithMaxWaiters(1))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := rb.PopContext(ctx)
+			errCh <- err
+		}()
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt64(&rb.popWaiters) == 1
+		}, time.Second, time.Millisecond)
+
+		_, err = rb.Pop()
+		assert.ErrorIs(t, err, ErrTooManyWaiters)
+		_, err = rb.PopTimeout(time.Millisecond)
+		assert.ErrorIs(t, err, ErrTooManyWaiters)
+
+		cancel()
+		assert.ErrorIs(t, <-errCh, context.Canceled)
+		assert.Equal(t, int64(0), atomic.LoadInt64(&rb.popWaiters))
+
+		go func() {
+			_, err := rb.Pop()
+			errCh <- err
+		}()
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt64(&rb.popWaiters) == 1
+		}, time.Second, time.Millisecond)
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		assert.NoError(t, <-errCh)
+		assert.Equal(t, int64(0), atomic.LoadInt64(&rb.popWaiters))
+	})
+	t.Run("max waiters on push", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithCapacity(1), WithMaxWaiters(1))
+		require.NoError(t, err)
+		require.NoError(t, rb.Push(&P{Int: 1}))
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- rb.Push(&P{Int: 2})
+		}()
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt64(&rb.pushWaiters) == 1
+		}, time.Second, time.Millisecond)
+
+		assert.ErrorIs(t, rb.Push(&P{Int: 3}), ErrTooManyWaiters)
+		ok, err := rb.TryPush(&P{Int: 3})
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		rb.Close()
+		assert.ErrorIs(t, <-errCh, Closed)
+		assert.Equal(t, int64(0), atomic.LoadInt64(&rb.pushWaiters))
+	})
+	t.Run("max waiters on push with cancellation", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithCapacity(1), WithMaxWaiters(1))
+		require.NoError(t, err)
+		require.NoError(t, rb.Push(&P{Int: 1}))
+
+		// Cancel each push while it is on its way into the wait, or already
+		// waiting, so that cancellation races with the waiter count.
+		for i := 0; i < 1000; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- rb.PushContext(ctx, &P{Int: 2})
+			}()
+			if i%2 == 0 {
+				require.Eventually(t, func() bool {
+					return atomic.LoadInt64(&rb.pushWaiters) == 1
+				}, time.Second, time.Microsecond)
+			}
+			cancel()
+			assert.ErrorIs(t, <-errCh, context.Canceled)
+			require.Equal(t, int64(0), atomic.LoadInt64(&rb.pushWaiters))
+		}
+
+		// The limit is free again, so the next push waits instead of
+		// being turned away.
+		assert.ErrorIs(t, rb.PushTimeout(&P{Int: 3}, time.Millisecond), ErrTimeout)
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("max waiters does not stop PopOk", func(t *testing.T) {
+		rb, err := NewCircularOpts[P, *P](WithMaxWaiters(1))
+		require.NoError(t, err)
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := rb.Pop()
+			errCh <- err
+		}()
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt64(&rb.popWaiters) == 1
+		}, time.Second, time.Millisecond)
+
+		okCh := make(chan bool, 1)
+		go func() {
+			_, ok := rb.PopOk()
+			okCh <- ok
+		}()
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt64(&rb.popWaiters) == 2
+		}, time.Second, time.Millisecond)
+		_, err = rb.Pop()
+		assert.ErrorIs(t, err, ErrTooManyWaiters)
+
+		require.NoError(t, rb.Push(&P{Int: 1}))
+		require.NoError(t, rb.Push(&P{Int: 2}))
+		assert.NoError(t, <-errCh)
+		assert.True(t, <-okCh)
+		assert.Equal(t, int64(0), atomic.LoadInt64(&rb.popWaiters))
+	})
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Priority is a bounded, blocking priority queue.
+//
+// Pop returns the element with the highest priority, as ordered by the less
+// function the queue was created with, and elements with the same priority
+// are returned in the order they were pushed. Like Circular, Push blocks the
+// caller while the queue is full, Pop blocks the caller while it is empty, and
+// both return Closed once the queue is closed.
+type Priority[T any, P Pointer[T]] struct {
+	lock     *sync.Mutex
+	notEmpty *notifier
+	notFull  *notifier
+	items    priorityHeap[T, P]
+	maxSize  int
+	seq      uint64
+	closed   bool
+}
+
+type priorityItem[T any, P Pointer[T]] struct {
+	value P
+	seq   uint64
+}
+
+// priorityHeap implements heap.Interface as a heap with the highest priority
+// element at the top.
+type priorityHeap[T any, P Pointer[T]] struct {
+	items []priorityItem[T, P]
+	less  func(a P, b P) bool
+}
+
+func (h *priorityHeap[T, P]) Len() int { return len(h.items) }
+
+func (h *priorityHeap[T, P]) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.less(a.value, b.value) {
+		return true
+	}
+	if h.less(b.value, a.value) {
+		return false
+	}
+	return a.seq < b.seq
+}
+
+func (h *priorityHeap[T, P]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *priorityHeap[T, P]) Push(x any) { h.items = append(h.items, x.(priorityItem[T, P])) }
+
+func (h *priorityHeap[T, P]) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items[n-1] = priorityItem[T, P]{}
+	h.items = h.items[:n-1]
+	return item
+}
+
+// NewPriority creates a new priority queue that holds up to capacity elements.
+// less reports whether a has a higher priority than b, so that Pop returns a
+// before b. A capacity of less than one is treated as one.
+func NewPriority[T any, P Pointer[T]](capacity int, less func(a P, b P) bool) *Priority[T, P] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	q := &Priority[T, P]{
+		lock:    new(sync.Mutex),
+		items:   priorityHeap[T, P]{items: make([]priorityItem[T, P], 0, capacity), less: less},
+		maxSize: capacity,
+	}
+	q.notEmpty = newNotifier(q.lock)
+	q.notFull = newNotifier(q.lock)
+	return q
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *Priority[T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.closed
+	q.lock.Unlock()
+	return
+}
+
+// Length returns the number of elements in the queue.
+func (q *Priority[T, P]) Length() (size int) {
+	q.lock.Lock()
+	size = q.items.Len()
+	q.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently and wakes up any blocked Push or Pop
+// calls, which return Closed. Like Circular.Close, it returns true if this
+// call closed the queue.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Priority[T, P]) Close() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	return true
+}
+
+// Push adds an element to the queue, blocking while the queue is full.
+func (q *Priority[T, P]) Push(p P) error {
+	return q.PushContext(context.Background(), p)
+}
+
+// PushContext is like Push, but stops waiting for space and returns the
+// context's error once ctx is done.
+func (q *Priority[T, P]) PushContext(ctx context.Context, p P) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for {
+		if q.closed {
+			return Closed
+		}
+		if q.items.Len() < q.maxSize {
+			break
+		}
+		if err := q.notFull.WaitContext(ctx); err != nil {
+			return err
+		}
+	}
+	heap.Push(&q.items, priorityItem[T, P]{value: p, seq: q.seq})
+	q.seq++
+	q.notEmpty.Signal()
+	return nil
+}
+
+// TryPush adds an element to the queue if there is space for it, without
+// blocking, and returns false without changing the queue if it is full.
+func (q *Priority[T, P]) TryPush(p P) (bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return false, Closed
+	}
+	if q.items.Len() >= q.maxSize {
+		return false, nil
+	}
+	heap.Push(&q.items, priorityItem[T, P]{value: p, seq: q.seq})
+	q.seq++
+	q.notEmpty.Signal()
+	return true, nil
+}
+
+// Pop removes and returns the element with the highest priority, blocking
+// until one is available.
+func (q *Priority[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext is like Pop, but stops waiting for an element and returns the
+// context's error once ctx is done.
+func (q *Priority[T, P]) PopContext(ctx context.Context) (P, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for {
+		if q.closed {
+			return nil, Closed
+		}
+		if q.items.Len() > 0 {
+			break
+		}
+		if err := q.notEmpty.WaitContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+	item := heap.Pop(&q.items).(priorityItem[T, P])
+	q.notFull.Signal()
+	return item.value, nil
+}
+
+// TryPop removes and returns the element with the highest priority if the
+// queue is not empty, without blocking, and returns false if it is.
+func (q *Priority[T, P]) TryPop() (P, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return nil, false, Closed
+	}
+	if q.items.Len() == 0 {
+		return nil, false, nil
+	}
+	item := heap.Pop(&q.items).(priorityItem[T, P])
+	q.notFull.Signal()
+	return item.value, true, nil
+}
+
+// Peek returns the element with the highest priority without removing it,
+// blocking until one is available.
+func (q *Priority[T, P]) Peek() (P, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for {
+		if q.closed {
+			return nil, Closed
+		}
+		if q.items.Len() > 0 {
+			// Like Unbounded.Peek, pass on a wakeup meant for a blocked Pop.
+			q.notEmpty.Signal()
+			return q.items.items[0].value, nil
+		}
+		q.notEmpty.Wait()
+	}
+}
+
+// Drain removes all elements from the queue and returns them in priority
+// order.
+func (q *Priority[T, P]) Drain() []P {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.items.Len() == 0 {
+		return nil
+	}
+	values := make([]P, 0, q.items.Len())
+	for q.items.Len() > 0 {
+		values = append(values, heap.Pop(&q.items).(priorityItem[T, P]).value)
+	}
+	q.notFull.Broadcast()
+	return values
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	higher := func(a *P, b *P) bool { return a.Int > b.Int }
+
+	t.Run("priority order", func(t *testing.T) {
+		q := NewPriority[P, *P](8, higher)
+		for _, i := range []int{3, 1, 4, 1, 5} {
+			require.NoError(t, q.Push(&P{Int: i}))
+		}
+		assert.Equal(t, 5, q.Length())
+		for _, i := range []int{5, 4, 3} {
+			actual, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+
+		// Interleave pushes with the remaining pops.
+		require.NoError(t, q.Push(&P{Int: 9}))
+		require.NoError(t, q.Push(&P{Int: 2}))
+		for _, i := range []int{9, 2, 1, 1} {
+			actual, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, actual.Int)
+		}
+		assert.Equal(t, 0, q.Length())
+	})
+	t.Run("equal priority is FIFO", func(t *testing.T) {
+		q := NewPriority[P, *P](4, higher)
+		for _, s := range []string{"a", "b", "c"} {
+			require.NoError(t, q.Push(&P{Int: 1, String: s}))
+		}
+		for _, s := range []string{"a", "b", "c"} {
+			actual, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, s, actual.String)
+		}
+	})
+	t.Run("pop blocks when empty", func(t *testing.T) {
+		q := NewPriority[P, *P](1, higher)
+		done := make(chan *P, 1)
+		go func() {
+			p, _ := q.Pop()
+			done <- p
+		}()
+		select {
+		case <-done:
+			t.Fatal("Pop should block on an empty queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+		require.NoError(t, q.Push(&P{Int: 1}))
+		assert.Equal(t, 1, (<-done).Int)
+	})
+	t.Run("push blocks when full", func(t *testing.T) {
+		q := NewPriority[P, *P](1, higher)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Push(&P{Int: 2})
+		}()
+		select {
+		case <-done:
+			t.Fatal("Push should block on a full queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+		actual, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.Int)
+		require.NoError(t, <-done)
+	})
+	t.Run("try push and peek", func(t *testing.T) {
+		q := NewPriority[P, *P](2, higher)
+		for i := 1; i <= 2; i++ {
+			ok, err := q.TryPush(&P{Int: i})
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+		ok, err := q.TryPush(&P{Int: 3})
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		peeked, err := q.Peek()
+		require.NoError(t, err)
+		assert.Equal(t, 2, peeked.Int)
+		assert.Equal(t, 2, q.Length())
+	})
+	t.Run("peek passes on its wakeup", func(t *testing.T) {
+		q := NewPriority[P, *P](1, higher)
+		peeked := make(chan *P, 1)
+		go func() {
+			p, _ := q.Peek()
+			peeked <- p
+		}()
+		time.Sleep(10 * time.Millisecond)
+		popped := make(chan *P, 1)
+		go func() {
+			p, _ := q.Pop()
+			popped <- p
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		require.NoError(t, q.Push(&P{Int: 1}))
+		assert.Equal(t, 1, (<-peeked).Int)
+		select {
+		case p := <-popped:
+			assert.Equal(t, 1, p.Int)
+		case <-time.After(time.Second):
+			t.Fatal("a blocked Pop was not woken up")
+		}
+	})
+	t.Run("context", func(t *testing.T) {
+		q := NewPriority[P, *P](1, higher)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := q.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		require.NoError(t, q.Push(&P{}))
+		assert.ErrorIs(t, q.PushContext(ctx, &P{}), context.DeadlineExceeded)
+	})
+	t.Run("closed", func(t *testing.T) {
+		q := NewPriority[P, *P](2, higher)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		require.NoError(t, q.Push(&P{Int: 2}))
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Push(&P{Int: 3})
+		}()
+		time.Sleep(10 * time.Millisecond)
+		q.Close()
+		assert.ErrorIs(t, <-done, Closed)
+		assert.True(t, q.IsClosed())
+		_, err := q.Pop()
+		assert.ErrorIs(t, err, Closed)
+
+		values := q.Drain()
+		require.Len(t, values, 2)
+		assert.Equal(t, 2, values[0].Int)
+		assert.Equal(t, 1, values[1].Int)
+	})
+	t.Run("consumer", func(t *testing.T) {
+		var consumer Consumer[P, *P] = NewPriority[P, *P](1, higher)
+		var producer Producer[P, *P] = consumer.(*Priority[P, *P])
+		assertProducerConsumer(t, producer, consumer)
+		require.NoError(t, producer.Push(&P{Int: 1}))
+		p, err := consumer.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+	})
+}
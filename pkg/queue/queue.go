@@ -10,8 +10,44 @@ var (
 	Closed     = errors.New("queue is closed")
 	FullError  = errors.New("queue is full")
 	EmptyError = errors.New("queue is empty")
+
+	DuplicateError  = errors.New("element is already in the queue")
+	OutOfRangeError = errors.New("index is out of range")
+	CapacityError   = errors.New("capacity is less than the length of the queue")
+
+	InvalidOptionsError = errors.New("invalid queue options")
+
+	ErrNilElement     = errors.New("element is nil")
+	ErrTimeout        = errors.New("timed out waiting for the queue")
+	ErrTooManyWaiters = errors.New("too many goroutines are waiting for the queue")
 )
 
+// closedError is the error returned by a queue that was closed with a cause.
+// It matches Closed when used with errors.Is, and unwraps to the cause.
+type closedError struct {
+	cause error
+}
+
+func (e *closedError) Error() string {
+	return Closed.Error() + ": " + e.cause.Error()
+}
+
+func (e *closedError) Is(target error) bool {
+	return target == Closed
+}
+
+func (e *closedError) Unwrap() error {
+	return e.cause
+}
+
+// closedErr returns the error a queue closed with the given cause should return.
+func closedErr(cause error) error {
+	if cause == nil {
+		return Closed
+	}
+	return &closedError{cause: cause}
+}
+
 // round takes an uint64 value and rounds up to the nearest power of 2
 func round(value uint64) uint64 {
 	value--
@@ -24,3 +60,25 @@ func round(value uint64) uint64 {
 	value++
 	return value
 }
+
+// Consumer is the receiving side of a queue. It allows elements to be
+// popped from the queue, with or without blocking, and the head of the
+// queue to be inspected, but does not allow elements to be pushed or the
+// queue to be closed.
+type Consumer[T any, P Pointer[T]] interface {
+	Pop() (P, error)
+	TryPop() (P, bool, error)
+	Peek() (P, error)
+	Length() int
+}
+
+// Producer is the sending side of a queue. It allows elements to be
+// pushed to the queue, with or without blocking, and the queue to be
+// closed, but does not allow elements to be popped. Close returns true
+// if that call closed the queue.
+type Producer[T any, P Pointer[T]] interface {
+	Push(P) error
+	TryPush(P) (bool, error)
+	Length() int
+	Close() bool
+}
@@ -3,9 +3,12 @@
 package queue
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRound(t *testing.T) {
@@ -32,3 +35,74 @@ func TestRound(t *testing.T) {
 		assert.Equalf(t, tc.expected, round(tc.in), "in: %d", tc.in)
 	}
 }
+
+func TestProducerClose(t *testing.T) {
+	t.Parallel()
+
+	// Not every queue implements all of Producer, but every queue's Close
+	// reports whether that call closed it.
+	type closer interface {
+		Close() bool
+	}
+	less := func(a *P, b *P) bool { return a.Int < b.Int }
+	producers := map[string]func() closer{
+		"circular":    func() closer { return NewCircular[P, *P](1) },
+		"lockfree":    func() closer { return NewLockFree[P, *P](1) },
+		"nonblocking": func() closer { return NewNonBlocking[P, *P](1) },
+		"dedup": func() closer {
+			return NewDedupQueue[int, P, *P](func(p *P) int { return p.Int }, 1)
+		},
+		"lease":     func() closer { return NewLeaseQueue[P, *P](1, 0) },
+		"priority":  func() closer { return NewPriority[P, *P](1, less) },
+		"spsc":      func() closer { return NewCircularSPSC[P, *P](1) },
+		"unbounded": func() closer { return NewCircularUnbounded[P, *P](1) },
+	}
+	for name, create := range producers {
+		create := create
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			q := create()
+			const goroutines = 8
+			var wg sync.WaitGroup
+			var owners int64
+			for i := 0; i < goroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if q.Close() {
+						atomic.AddInt64(&owners, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, int64(1), owners)
+			assert.False(t, q.Close())
+		})
+	}
+}
+
+// assertProducerConsumer checks the non-blocking side of the Producer and
+// Consumer interfaces of an empty queue that holds at least one element.
+func assertProducerConsumer(t *testing.T, producer Producer[P, *P], consumer Consumer[P, *P]) {
+	t.Helper()
+
+	_, ok, err := consumer.TryPop()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	p := &P{Int: 1}
+	ok, err = producer.TryPush(p)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	peeked, err := consumer.Peek()
+	require.NoError(t, err)
+	assert.Same(t, p, peeked)
+	assert.Equal(t, 1, consumer.Length())
+
+	actual, ok, err := consumer.TryPop()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Same(t, p, actual)
+	assert.Equal(t, 0, producer.Length())
+}
@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// SPSC is a lock-free, fixed size FIFO queue for exactly one producer
+// goroutine and exactly one consumer goroutine.
+//
+// IT IS NOT SAFE FOR ANY OTHER USE. Only one goroutine may ever call Push and
+// only one goroutine may ever call Pop. Calling either from more than one
+// goroutine, even at different times without synchronizing them, corrupts the
+// queue silently. Use Circular if there can be more than one producer or more
+// than one consumer.
+//
+// The producer only ever writes tail and the consumer only ever writes head,
+// so instead of a lock, each side publishes its progress with an atomic store
+// that the other side observes with an atomic load. Like LockFree, a Push on
+// a full queue or a Pop on an empty queue spins, yielding the processor, until
+// the other side makes progress or the queue is closed.
+type SPSC[T any, P Pointer[T]] struct {
+	_padding0 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	head      uint64
+	_padding1 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	tail      uint64
+	_padding2 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	mask      uint64
+	nodes     []P
+	_padding3 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	closed    uint64
+}
+
+// NewCircularSPSC creates a new single producer, single consumer queue that
+// holds at least capacity elements, rounded up to the nearest power of 2.
+func NewCircularSPSC[T any, P Pointer[T]](capacity int) *SPSC[T, P] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	size := round(uint64(capacity))
+	return &SPSC[T, P]{
+		mask:  size - 1,
+		nodes: make([]P, size),
+	}
+}
+
+// Close closes the queue permanently, which makes a spinning Push or Pop
+// return Closed. It is safe to call from any goroutine, and like
+// Circular.Close, it returns true if this call closed the queue.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *SPSC[T, P]) Close() bool {
+	return atomic.CompareAndSwapUint64(&q.closed, 0, 1)
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *SPSC[T, P]) IsClosed() bool {
+	return atomic.LoadUint64(&q.closed) == 1
+}
+
+// Length returns the number of elements in the queue. It is safe to call from
+// any goroutine, but is only exact when called by the producer or consumer.
+func (q *SPSC[T, P]) Length() int {
+	head := atomic.LoadUint64(&q.head)
+	return int(atomic.LoadUint64(&q.tail) - head)
+}
+
+// Push adds an element to the queue, spinning while the queue is full.
+// It must only be called by the producer goroutine.
+func (q *SPSC[T, P]) Push(p P) error {
+	tail := q.tail
+	for tail-atomic.LoadUint64(&q.head) > q.mask {
+		if q.IsClosed() {
+			return Closed
+		}
+		runtime.Gosched()
+	}
+	if q.IsClosed() {
+		return Closed
+	}
+	q.nodes[tail&q.mask] = p
+	// Publishing the new tail releases the element to the consumer.
+	atomic.StoreUint64(&q.tail, tail+1)
+	return nil
+}
+
+// TryPush adds an element to the queue if there is space for it, without
+// spinning, and returns false if it is full. It must only be called by the
+// producer goroutine.
+func (q *SPSC[T, P]) TryPush(p P) (bool, error) {
+	if q.IsClosed() {
+		return false, Closed
+	}
+	tail := q.tail
+	if tail-atomic.LoadUint64(&q.head) > q.mask {
+		return false, nil
+	}
+	q.nodes[tail&q.mask] = p
+	atomic.StoreUint64(&q.tail, tail+1)
+	return true, nil
+}
+
+// Pop removes an element from the queue, spinning while the queue is empty.
+// It must only be called by the consumer goroutine.
+func (q *SPSC[T, P]) Pop() (P, error) {
+	head := q.head
+	for head == atomic.LoadUint64(&q.tail) {
+		if q.IsClosed() {
+			return nil, Closed
+		}
+		runtime.Gosched()
+	}
+	if q.IsClosed() {
+		return nil, Closed
+	}
+	p := q.nodes[head&q.mask]
+	q.nodes[head&q.mask] = nil
+	// Publishing the new head releases the slot back to the producer.
+	atomic.StoreUint64(&q.head, head+1)
+	return p, nil
+}
+
+// TryPop removes an element from the queue if one is available, without
+// spinning, and returns false if it is empty. It must only be called by the
+// consumer goroutine.
+func (q *SPSC[T, P]) TryPop() (P, bool, error) {
+	if q.IsClosed() {
+		return nil, false, Closed
+	}
+	head := q.head
+	if head == atomic.LoadUint64(&q.tail) {
+		return nil, false, nil
+	}
+	p := q.nodes[head&q.mask]
+	q.nodes[head&q.mask] = nil
+	atomic.StoreUint64(&q.head, head+1)
+	return p, true, nil
+}
+
+// Peek returns the element at the head of the queue without removing it,
+// spinning while the queue is empty. It must only be called by the consumer
+// goroutine, since only the consumer can be sure that the element is not
+// removed in between.
+func (q *SPSC[T, P]) Peek() (P, error) {
+	head := q.head
+	for head == atomic.LoadUint64(&q.tail) {
+		if q.IsClosed() {
+			return nil, Closed
+		}
+		runtime.Gosched()
+	}
+	if q.IsClosed() {
+		return nil, Closed
+	}
+	return q.nodes[head&q.mask], nil
+}
+
+// Drain drains all the elements in the queue and returns them in FIFO order.
+//
+// It must only be called by the consumer goroutine, or once the producer and
+// consumer goroutines have both stopped, for example after Close.
+func (q *SPSC[T, P]) Drain() []P {
+	head, tail := q.head, atomic.LoadUint64(&q.tail)
+	if head == tail {
+		return nil
+	}
+	values := make([]P, 0, tail-head)
+	for ; head != tail; head++ {
+		values = append(values, q.nodes[head&q.mask])
+		q.nodes[head&q.mask] = nil
+	}
+	atomic.StoreUint64(&q.head, head)
+	return values
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPSC(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		q := NewCircularSPSC[P, *P](3)
+		assert.Equal(t, 4, len(q.nodes))
+		for i := 0; i < 4; i++ {
+			require.NoError(t, q.Push(&P{Int: i}))
+		}
+		assert.Equal(t, 4, q.Length())
+		for i := 0; i < 4; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+		assert.Equal(t, 0, q.Length())
+	})
+	t.Run("push blocks when full", func(t *testing.T) {
+		q := NewCircularSPSC[P, *P](1)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Push(&P{Int: 2})
+		}()
+		select {
+		case <-done:
+			t.Fatal("SPSC did not block on full write")
+		case <-time.After(10 * time.Millisecond):
+		}
+		p, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+		require.NoError(t, <-done)
+		p, err = q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 2, p.Int)
+	})
+	t.Run("close wakes blocked push and pop", func(t *testing.T) {
+		empty := NewCircularSPSC[P, *P](1)
+		popped := make(chan error, 1)
+		go func() {
+			_, err := empty.Pop()
+			popped <- err
+		}()
+		full := NewCircularSPSC[P, *P](1)
+		require.NoError(t, full.Push(&P{}))
+		pushed := make(chan error, 1)
+		go func() {
+			pushed <- full.Push(&P{})
+		}()
+		empty.Close()
+		full.Close()
+		assert.ErrorIs(t, <-popped, Closed)
+		assert.ErrorIs(t, <-pushed, Closed)
+		assert.True(t, full.IsClosed())
+		values := full.Drain()
+		require.Len(t, values, 1)
+		assert.Nil(t, full.Drain())
+	})
+	t.Run("one million items", func(t *testing.T) {
+		const count = 1000000
+		q := NewCircularSPSC[P, *P](1024)
+		items := make([]P, count)
+		done := make(chan error, 1)
+		go func() {
+			for i := range items {
+				items[i].Int = i
+				if err := q.Push(&items[i]); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+		for i := 0; i < count; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			if p.Int != i {
+				require.Equal(t, i, p.Int)
+			}
+		}
+		require.NoError(t, <-done)
+		assert.Equal(t, 0, q.Length())
+	})
+	t.Run("producer and consumer", func(t *testing.T) {
+		q := NewCircularSPSC[P, *P](1)
+		var producer Producer[P, *P] = q
+		var consumer Consumer[P, *P] = q
+		assertProducerConsumer(t, producer, consumer)
+		require.NoError(t, producer.Push(&P{Int: 1}))
+		p, err := consumer.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+	})
+}
+
+func BenchmarkSPSC(b *testing.B) {
+	b.Run("spsc", func(b *testing.B) {
+		q := NewCircularSPSC[P, *P](1024)
+		p := new(P)
+		b.ReportAllocs()
+		b.ResetTimer()
+		doneCh := make(chan struct{})
+		go func() {
+			for i := 0; i < b.N; i++ {
+				_, _ = q.Pop()
+			}
+			close(doneCh)
+		}()
+		for i := 0; i < b.N; i++ {
+			_ = q.Push(p)
+		}
+		<-doneCh
+	})
+	b.Run("circular", func(b *testing.B) {
+		q := NewCircular[P, *P](1024)
+		p := new(P)
+		b.ReportAllocs()
+		b.ResetTimer()
+		doneCh := make(chan struct{})
+		go func() {
+			for i := 0; i < b.N; i++ {
+				_, _ = q.Pop()
+			}
+			close(doneCh)
+		}()
+		for i := 0; i < b.N; i++ {
+			_ = q.Push(p)
+		}
+		<-doneCh
+	})
+}
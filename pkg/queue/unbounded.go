@@ -0,0 +1,238 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultSegmentSize is the number of elements held by each segment of an
+// Unbounded queue when NewCircularUnbounded is given a segment size of zero.
+const DefaultSegmentSize = 1024
+
+// Unbounded is a FIFO queue that never blocks on Push. Instead of reallocating
+// and copying a single backing array when it runs out of space, it grows by
+// allocating fixed-size segments that are linked together, and frees each
+// segment once every element in it has been popped. The most recently freed
+// segment is kept and reused by the next segment allocation, so a queue whose
+// length hovers around a segment boundary does not allocate on every push.
+//
+// It is thread safe. Pop blocks the caller if the queue is empty.
+type Unbounded[T any, P Pointer[T]] struct {
+	lock        *sync.Mutex
+	notEmpty    *notifier
+	head        *segment[T, P]
+	headIndex   int
+	tail        *segment[T, P]
+	tailIndex   int
+	spare       *segment[T, P]
+	segmentSize int
+	length      int
+	closed      bool
+	err         error
+}
+
+// segment is a fixed-size block of elements in an Unbounded queue.
+type segment[T any, P Pointer[T]] struct {
+	nodes []P
+	next  *segment[T, P]
+}
+
+// NewCircularUnbounded creates a new unbounded queue that grows by segments
+// of the given number of elements. A segment size of zero or less means
+// DefaultSegmentSize.
+func NewCircularUnbounded[T any, P Pointer[T]](segmentSize int) *Unbounded[T, P] {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	q := &Unbounded[T, P]{
+		lock:        new(sync.Mutex),
+		segmentSize: segmentSize,
+	}
+	q.notEmpty = newNotifier(q.lock)
+	q.head = q.newSegment()
+	q.tail = q.head
+	return q
+}
+
+// newSegment is an internal function used to get an empty segment, reusing
+// the last freed segment if there is one.
+func (q *Unbounded[T, P]) newSegment() (s *segment[T, P]) {
+	if q.spare != nil {
+		s, q.spare = q.spare, nil
+		return
+	}
+	return &segment[T, P]{nodes: make([]P, q.segmentSize)}
+}
+
+// IsEmpty returns true if the queue is empty.
+func (q *Unbounded[T, P]) IsEmpty() (empty bool) {
+	q.lock.Lock()
+	empty = q.length == 0
+	q.lock.Unlock()
+	return
+}
+
+// IsClosed returns true if the queue is closed.
+func (q *Unbounded[T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.closed
+	q.lock.Unlock()
+	return
+}
+
+// Length returns the number of elements in the queue.
+func (q *Unbounded[T, P]) Length() (size int) {
+	q.lock.Lock()
+	size = q.length
+	q.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently.
+//
+// Like Circular.Close, it returns true if this call closed the queue.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Unbounded[T, P]) Close() bool {
+	return q.CloseWithCause(nil)
+}
+
+// CloseWithCause closes the queue permanently, recording why it was closed,
+// like Circular.CloseWithCause.
+func (q *Unbounded[T, P]) CloseWithCause(cause error) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed = true
+	q.err = closedErr(cause)
+	q.notEmpty.Broadcast()
+	return true
+}
+
+// Push adds an element to the queue. It never blocks, allocating a new
+// segment if the last one is full.
+func (q *Unbounded[T, P]) Push(p P) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return q.err
+	}
+	if q.tailIndex == q.segmentSize {
+		q.tail.next = q.newSegment()
+		q.tail = q.tail.next
+		q.tailIndex = 0
+	}
+	q.tail.nodes[q.tailIndex] = p
+	q.tailIndex++
+	q.length++
+	q.notEmpty.Signal()
+	return nil
+}
+
+// TryPush is like Push. Since Push never blocks, it only returns false if the
+// queue is closed, along with the same error as Push.
+func (q *Unbounded[T, P]) TryPush(p P) (bool, error) {
+	if err := q.Push(p); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Pop removes an element from the queue, blocking until one is available.
+func (q *Unbounded[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext is like Pop, but stops waiting for an element and returns the
+// context's error once ctx is done.
+func (q *Unbounded[T, P]) PopContext(ctx context.Context) (P, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for {
+		if q.closed {
+			return nil, q.err
+		}
+		if q.length > 0 {
+			return q.pop(), nil
+		}
+		if err := q.notEmpty.WaitContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// TryPop removes an element from the queue if one is available, without
+// blocking, and returns false if the queue is empty.
+func (q *Unbounded[T, P]) TryPop() (P, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return nil, false, q.err
+	}
+	if q.length == 0 {
+		return nil, false, nil
+	}
+	return q.pop(), true, nil
+}
+
+// Peek returns the element at the head of the queue without removing it,
+// blocking until one is available.
+func (q *Unbounded[T, P]) Peek() (P, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for {
+		if q.closed {
+			return nil, q.err
+		}
+		if q.length > 0 {
+			// The element stays in the queue, so pass on a wakeup
+			// that may have been meant for a blocked Pop.
+			q.notEmpty.Signal()
+			return q.head.nodes[q.headIndex], nil
+		}
+		q.notEmpty.Wait()
+	}
+}
+
+// pop is an internal function used to remove the element at the head of the
+// queue, which must not be empty, freeing the head segment once it has been
+// fully consumed.
+func (q *Unbounded[T, P]) pop() (p P) {
+	p = q.head.nodes[q.headIndex]
+	q.head.nodes[q.headIndex] = nil
+	q.headIndex++
+	q.length--
+	if q.headIndex == q.segmentSize && q.head != q.tail {
+		freed := q.head
+		q.head = freed.next
+		q.headIndex = 0
+		freed.next = nil
+		q.spare = freed
+	}
+	if q.length == 0 {
+		// Rewind the last segment so that an empty queue
+		// does not allocate a new segment on the next push.
+		q.headIndex = 0
+		q.tailIndex = 0
+	}
+	return
+}
+
+// Drain drains all the elements in the queue and returns them in FIFO order,
+// keeping a single empty segment.
+func (q *Unbounded[T, P]) Drain() []P {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.length == 0 {
+		return nil
+	}
+	values := make([]P, 0, q.length)
+	for q.length > 0 {
+		values = append(values, q.pop())
+	}
+	return values
+}
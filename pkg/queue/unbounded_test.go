@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnbounded(t *testing.T) {
+	t.Parallel()
+
+	t.Run("grows by segments", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](4)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, q.Push(&P{Int: i}))
+		}
+		assert.Equal(t, 10, q.Length())
+		segments := 0
+		for s := q.head; s != nil; s = s.next {
+			segments++
+		}
+		assert.Equal(t, 3, segments)
+
+		for i := 0; i < 10; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+		assert.True(t, q.IsEmpty())
+		assert.Equal(t, q.head, q.tail)
+		assert.Nil(t, q.head.next)
+	})
+	t.Run("frees drained segments", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](2)
+		for i := 0; i < 6; i++ {
+			require.NoError(t, q.Push(&P{Int: i}))
+		}
+		first := q.head
+		for i := 0; i < 2; i++ {
+			_, err := q.Pop()
+			require.NoError(t, err)
+		}
+		assert.NotEqual(t, first, q.head)
+		assert.Equal(t, first, q.spare)
+		for _, p := range first.nodes {
+			assert.Nil(t, p)
+		}
+		require.NoError(t, q.Push(&P{Int: 6}))
+		assert.Equal(t, first, q.tail)
+		assert.Nil(t, q.spare)
+		assert.Equal(t, 5, q.Length())
+
+		values := q.Drain()
+		require.Len(t, values, 5)
+		for i, p := range values {
+			assert.Equal(t, i+2, p.Int)
+		}
+		assert.Equal(t, 0, q.Length())
+	})
+	t.Run("default segment size", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](0)
+		assert.Equal(t, DefaultSegmentSize, len(q.head.nodes))
+	})
+	t.Run("pop blocks until push", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](2)
+		done := make(chan *P, 1)
+		go func() {
+			p, _ := q.Pop()
+			done <- p
+		}()
+		select {
+		case <-done:
+			t.Fatal("Pop should block on an empty queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+		require.NoError(t, q.Push(&P{Int: 1}))
+		assert.Equal(t, 1, (<-done).Int)
+	})
+	t.Run("peek passes on its wakeup", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](2)
+		peeked := make(chan *P, 1)
+		go func() {
+			p, _ := q.Peek()
+			peeked <- p
+		}()
+		time.Sleep(10 * time.Millisecond)
+		popped := make(chan *P, 1)
+		go func() {
+			p, _ := q.Pop()
+			popped <- p
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		require.NoError(t, q.Push(&P{Int: 1}))
+		assert.Equal(t, 1, (<-peeked).Int)
+		select {
+		case p := <-popped:
+			assert.Equal(t, 1, p.Int)
+		case <-time.After(time.Second):
+			t.Fatal("a blocked Pop was not woken up")
+		}
+	})
+	t.Run("context", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](2)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := q.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](16)
+		const producers, count = 4, 1000
+		var wg sync.WaitGroup
+		for i := 0; i < producers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < count; j++ {
+					assert.NoError(t, q.Push(&P{Int: j}))
+				}
+			}()
+		}
+		for i := 0; i < producers*count; i++ {
+			_, err := q.Pop()
+			require.NoError(t, err)
+		}
+		wg.Wait()
+		assert.Equal(t, 0, q.Length())
+	})
+	t.Run("closed", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](2)
+		require.NoError(t, q.Push(&P{Int: 1}))
+		done := make(chan error, 1)
+		empty := NewCircularUnbounded[P, *P](2)
+		go func() {
+			_, err := empty.Pop()
+			done <- err
+		}()
+		cause := errors.New("shutdown")
+		empty.CloseWithCause(cause)
+		assert.ErrorIs(t, <-done, cause)
+
+		q.Close()
+		assert.True(t, q.IsClosed())
+		assert.ErrorIs(t, q.Push(&P{}), Closed)
+		_, err := q.Pop()
+		assert.ErrorIs(t, err, Closed)
+		values := q.Drain()
+		require.Len(t, values, 1)
+		assert.Equal(t, 1, values[0].Int)
+	})
+	t.Run("producer and consumer", func(t *testing.T) {
+		q := NewCircularUnbounded[P, *P](2)
+		var producer Producer[P, *P] = q
+		var consumer Consumer[P, *P] = q
+		assertProducerConsumer(t, producer, consumer)
+		require.NoError(t, producer.Push(&P{Int: 1}))
+		p, err := consumer.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+	})
+}
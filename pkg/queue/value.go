@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync"
+)
+
+// CircularValue is a blocking FIFO queue like Circular that stores elements of
+// type T by value instead of by pointer.
+//
+// Storing small structs directly in the backing array avoids allocating each
+// element on the heap and the pointer indirection that goes with it. Elements
+// are copied when they are pushed and when they are popped.
+type CircularValue[T any] struct {
+	_padding0 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	head      uint64
+	_padding1 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	tail      uint64
+	_padding2 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	maxSize   uint64
+	_padding3 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	closed    bool
+	err       error
+	_padding4 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	lock      *sync.Mutex
+	_padding5 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	notEmpty  *notifier
+	_padding6 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	notFull   *notifier
+	_padding7 [cacheLinePadding]uint64 //nolint:structcheck,unused
+	nodes     []T
+}
+
+// NewCircularValue creates a new circular queue of values with the given size.
+func NewCircularValue[T any](maxSize uint64) *CircularValue[T] {
+	q := new(CircularValue[T])
+	q.lock = new(sync.Mutex)
+	q.notFull = newNotifier(q.lock)
+	q.notEmpty = newNotifier(q.lock)
+	q.maxSize = slots(maxSize)
+	q.nodes = make([]T, q.maxSize)
+	return q
+}
+
+// IsEmpty returns true if the queue is empty.
+func (q *CircularValue[T]) IsEmpty() (empty bool) {
+	q.lock.Lock()
+	empty = q.head == q.tail
+	q.lock.Unlock()
+	return
+}
+
+// IsFull returns true if the queue is full.
+func (q *CircularValue[T]) IsFull() (full bool) {
+	q.lock.Lock()
+	full = q.isFull()
+	q.lock.Unlock()
+	return
+}
+
+// isFull is an internal function used to check if the queue is full.
+func (q *CircularValue[T]) isFull() bool {
+	return q.head == (q.tail+1)%q.maxSize
+}
+
+// IsClosed returns true if the queue is closed.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *CircularValue[T]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.closed
+	q.lock.Unlock()
+	return
+}
+
+// Length returns the number of elements in the queue.
+func (q *CircularValue[T]) Length() (size int) {
+	q.lock.Lock()
+	size = q.length()
+	q.lock.Unlock()
+	return
+}
+
+// length is an internal function used to get the number of elements in the queue.
+func (q *CircularValue[T]) length() int {
+	if q.tail < q.head {
+		return int(q.maxSize - q.head + q.tail)
+	}
+	return int(q.tail - q.head)
+}
+
+// Close closes the queue permanently.
+//
+// Like Circular.Close, it returns true if this call closed the queue.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *CircularValue[T]) Close() bool {
+	return q.CloseWithCause(nil)
+}
+
+// CloseWithCause closes the queue permanently, recording why it was closed,
+// in the same way as Circular.CloseWithCause.
+func (q *CircularValue[T]) CloseWithCause(cause error) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed = true
+	q.err = closedErr(cause)
+	q.notFull.Broadcast()
+	q.notEmpty.Broadcast()
+	return true
+}
+
+// Push adds an element to the queue, blocking while the queue is full.
+func (q *CircularValue[T]) Push(v T) error {
+	q.lock.Lock()
+LOOP:
+	if q.closed {
+		q.lock.Unlock()
+		return q.err
+	}
+	if q.isFull() {
+		q.notFull.Wait()
+		goto LOOP
+	}
+
+	q.nodes[q.tail] = v
+	q.tail = (q.tail + 1) % q.maxSize
+	q.notEmpty.Signal()
+	q.lock.Unlock()
+	return nil
+}
+
+// Pop removes an element from the queue, blocking while the queue is empty.
+func (q *CircularValue[T]) Pop() (v T, err error) {
+	q.lock.Lock()
+LOOP:
+	if q.closed {
+		q.lock.Unlock()
+		return v, q.err
+	}
+	if q.head == q.tail {
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	v = q.nodes[q.head]
+	var zero T
+	q.nodes[q.head] = zero
+	q.head = (q.head + 1) % q.maxSize
+	q.notFull.Signal()
+	q.lock.Unlock()
+	return
+}
+
+// WithSlices calls f with the elements currently in the queue, in FIFO order,
+// without copying them, in the same way as Circular.WithSlices. The queue's
+// lock is held while f runs, so f must not modify the slices or call any other
+// method on the queue.
+func (q *CircularValue[T]) WithSlices(f func(first []T, second []T)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.tail < q.head {
+		f(q.nodes[q.head:], q.nodes[:q.tail])
+		return
+	}
+	f(q.nodes[q.head:q.tail], nil)
+}
+
+// Drain removes all elements from the queue and returns them in FIFO order,
+// waking up blocked producers. It can be called after the queue is closed to
+// recover the elements left in it.
+func (q *CircularValue[T]) Drain() []T {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.head == q.tail {
+		return nil
+	}
+	values := make([]T, 0, q.length())
+	var zero T
+	for q.head != q.tail {
+		values = append(values, q.nodes[q.head])
+		q.nodes[q.head] = zero
+		q.head = (q.head + 1) % q.maxSize
+	}
+	q.head = 0
+	q.tail = 0
+	q.notFull.Broadcast()
+	return values
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// V is a 16-byte value type used to test CircularValue.
+type V struct {
+	A int64
+	B int64
+}
+
+func TestCircularValue(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fifo", func(t *testing.T) {
+		q := NewCircularValue[V](3)
+		assert.True(t, q.IsEmpty())
+		for i := int64(1); i <= 3; i++ {
+			require.NoError(t, q.Push(V{A: i, B: -i}))
+		}
+		assert.True(t, q.IsFull())
+		assert.Equal(t, 3, q.Length())
+
+		v, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, V{A: 1, B: -1}, v)
+		require.NoError(t, q.Push(V{A: 4}))
+
+		_, err = q.Pop()
+		require.NoError(t, err)
+		require.NoError(t, q.Push(V{A: 5}))
+
+		var values []V
+		wrapped := false
+		q.WithSlices(func(first []V, second []V) {
+			values = append(append(values, first...), second...)
+			wrapped = len(second) > 0
+		})
+		assert.True(t, wrapped)
+		assert.Equal(t, []V{{A: 3, B: -3}, {A: 4}, {A: 5}}, values)
+
+		assert.Equal(t, []V{{A: 3, B: -3}, {A: 4}, {A: 5}}, q.Drain())
+		assert.Equal(t, 0, q.Length())
+		assert.Nil(t, q.Drain())
+	})
+	t.Run("blocking", func(t *testing.T) {
+		q := NewCircularValue[V](1)
+		require.NoError(t, q.Push(V{A: 1}))
+
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Push(V{A: 2})
+		}()
+		select {
+		case <-done:
+			t.Fatal("Push should block on a full queue")
+		case <-time.After(10 * time.Millisecond):
+		}
+		v, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), v.A)
+		require.NoError(t, <-done)
+		v, err = q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), v.A)
+	})
+	t.Run("close", func(t *testing.T) {
+		q := NewCircularValue[V](1)
+		done := make(chan error, 1)
+		go func() {
+			_, err := q.Pop()
+			done <- err
+		}()
+		cause := errors.New("upstream failed")
+		q.CloseWithCause(cause)
+		q.Close()
+		err := <-done
+		assert.ErrorIs(t, err, Closed)
+		assert.ErrorIs(t, err, cause)
+		assert.True(t, q.IsClosed())
+		assert.ErrorIs(t, q.Push(V{}), Closed)
+	})
+}
+
+func BenchmarkCircularValue(b *testing.B) {
+	b.Run("values", func(b *testing.B) {
+		q := NewCircularValue[V](1024)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_ = q.Push(V{A: int64(i)})
+			_, _ = q.Pop()
+		}
+	})
+	b.Run("pointers", func(b *testing.B) {
+		q := NewCircular[V, *V](1024)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_ = q.Push(&V{A: int64(i)})
+			_, _ = q.Pop()
+		}
+	})
+}
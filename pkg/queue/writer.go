@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"io"
+	"net"
+)
+
+// DrainToWriter removes all the byte slices currently in q and writes them, in
+// FIFO order, to w, returning the total number of bytes written.
+//
+// Go does not allow a method to constrain the queue's element type further, so
+// this is a function that only accepts queues of byte slices rather than a
+// method on Circular. The elements are written as net.Buffers, so when w is a
+// connection that supports vectored writes, such as a *net.TCPConn, all of
+// them are flushed with a single writev call.
+//
+// The elements are removed from the queue in a single batch before they are
+// written, so the queue's lock is not held while writing to w. If the write
+// fails, the bytes that were not written are put back at the head of the
+// queue, in order, before the error is returned, so no data is lost. It
+// returns Closed if the queue is closed.
+func DrainToWriter[P Pointer[[]byte]](q *Circular[[]byte, P], w io.Writer) (int64, error) {
+	q.lock.Lock()
+	if q.isClosed() {
+		err := q.err
+		q.lock.Unlock()
+		return 0, err
+	}
+	values := q.drain()
+	q.notFull.Broadcast()
+	q.lock.Unlock()
+
+	buffers := make(net.Buffers, 0, len(values))
+	for _, p := range values {
+		if p != nil && len(*p) > 0 {
+			buffers = append(buffers, *p)
+		}
+	}
+	n, err := buffers.WriteTo(w)
+	if err != nil && len(buffers) > 0 {
+		// WriteTo consumes the buffers as they are written, so what is
+		// left of them is exactly the data that still has to be written.
+		values := make([]P, len(buffers))
+		for i := range buffers {
+			values[i] = P(&buffers[i])
+		}
+		q.requeue(values...)
+	}
+	return n, err
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// limitedWriter is an io.Writer that fails once it has accepted limit bytes.
+type limitedWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+var errLimit = errors.New("write limit reached")
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if room := w.limit - w.Len(); len(p) > room {
+		n, _ := w.Buffer.Write(p[:room])
+		return n, errLimit
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestDrainToWriter(t *testing.T) {
+	t.Parallel()
+
+	bytesOf := func(s string) *[]byte {
+		b := []byte(s)
+		return &b
+	}
+
+	rb := NewCircular[[]byte](4)
+	require.NoError(t, rb.Push(bytesOf("hello ")))
+	require.NoError(t, rb.Push(bytesOf("")))
+	require.NoError(t, rb.Push(bytesOf("world")))
+	var buf bytes.Buffer
+	n, err := DrainToWriter(rb, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.Equal(t, "hello world", buf.String())
+	assert.Equal(t, 0, rb.Length())
+
+	n, err = DrainToWriter(rb, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	t.Run("failed write keeps the rest", func(t *testing.T) {
+		rb := NewCircular[[]byte](3)
+		require.NoError(t, rb.Push(bytesOf("abc")))
+		require.NoError(t, rb.Push(bytesOf("def")))
+		require.NoError(t, rb.Push(bytesOf("ghi")))
+		w := &limitedWriter{limit: 4}
+		n, err := DrainToWriter(rb, w)
+		assert.ErrorIs(t, err, errLimit)
+		assert.Equal(t, int64(4), n)
+		assert.Equal(t, "abcd", w.String())
+
+		values := rb.Drain()
+		require.Len(t, values, 2)
+		assert.Equal(t, "ef", string(*values[0]))
+		assert.Equal(t, "ghi", string(*values[1]))
+	})
+	t.Run("closed", func(t *testing.T) {
+		rb := NewCircular[[]byte](1)
+		require.NoError(t, rb.Push(bytesOf("a")))
+		rb.Close()
+		_, err := DrainToWriter(rb, &bytes.Buffer{})
+		assert.ErrorIs(t, err, Closed)
+		assert.Equal(t, 1, rb.Length())
+	})
+}